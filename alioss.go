@@ -3,31 +3,28 @@ package alioss
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
 
 // Main entry point for service manipulation
 type AliOss struct {
-	Log    *log.Logger
-	Svc    *oss.Client
-	Region string
-	Bucket string
-}
-
-type downloader struct {
-	AliOss
-
-	File       *os.File
-	FileOffset int64
-	Err        error
+	Log      *log.Logger
+	Svc      *oss.Client
+	Backend  Backend // Used instead of Svc if set
+	Region   string
+	Bucket   string
+	Transfer TransferConfig // Defaults of uploads and downloads
+	Retry    RetryPolicy    // Retries of all requests, transfers override number of attempts by their Retries or DefaultUploadRetries
 }
 
 type filePart struct {
@@ -69,25 +66,49 @@ func (alioss AliOss) IsRegionValid(name string) error {
 	return fmt.Errorf("Failed to validate region: %s", name)
 }
 
+// Get backend of requests, which retries failed requests
+func (alioss AliOss) backend() Backend {
+	return retryingBackend{backend: alioss.baseBackend(), policy: alioss.Retry, log: alioss.Log}
+}
+
+// Get backend of requests of transfer with "cfg"
+func (alioss AliOss) transferBackend(cfg TransferConfig) Backend {
+	return retryingBackend{backend: alioss.baseBackend(), policy: cfg.retryPolicy(alioss.Retry), log: alioss.Log}
+}
+
+func (alioss AliOss) baseBackend() Backend {
+	if alioss.Backend != nil {
+		return alioss.Backend
+	}
+
+	return ossBackend{client: alioss.Svc}
+}
+
 // List available buckets
 func (alioss AliOss) GetBucketsList() (list []string, err error) {
-	result, err := alioss.Svc.ListBuckets()
+	return alioss.GetBucketsListContext(context.Background())
+}
+
+// Same as GetBucketsList with context
+func (alioss AliOss) GetBucketsListContext(ctx context.Context) (list []string, err error) {
+	list, err = alioss.backend().ListBuckets(ctx)
 	if err != nil {
 		alioss.Log.Printf("Failed to list buckets: %s\n", err)
 		return
 	}
 
-	for _, bucket := range result.Buckets {
-		list = append(list, bucket.Name)
-	}
-
 	alioss.Log.Println("Get buckets:", list)
 	return
 }
 
 // Create bucket if doesn't exists
 func (alioss AliOss) CreateBucket(name string) error {
-	buckets, err := alioss.GetBucketsList()
+	return alioss.CreateBucketContext(context.Background(), name)
+}
+
+// Same as CreateBucket with context
+func (alioss AliOss) CreateBucketContext(ctx context.Context, name string) error {
+	buckets, err := alioss.GetBucketsListContext(ctx)
 	if err != nil {
 		return err
 	}
@@ -99,7 +120,7 @@ func (alioss AliOss) CreateBucket(name string) error {
 		return nil
 	}
 
-	err = alioss.Svc.CreateBucket(name)
+	err = alioss.backend().CreateBucket(ctx, name)
 	if err != nil {
 		alioss.Log.Printf("Failed to create bucket %s: %s", name, err)
 		return err
@@ -111,16 +132,15 @@ func (alioss AliOss) CreateBucket(name string) error {
 
 // Create folder
 func (alioss AliOss) CreateFolder(path string) error {
+	return alioss.CreateFolderContext(context.Background(), path)
+}
+
+// Same as CreateFolder with context
+func (alioss AliOss) CreateFolderContext(ctx context.Context, path string) error {
 	path = strings.TrimPrefix(path, "/")
 	path = strings.TrimSuffix(path, "/")
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed to create folder %s: %s\n", path, err)
-		return err
-	}
-	var emptyReader io.Reader
-	err = bucket.PutObject(path+"/", emptyReader)
+	err := alioss.backend().PutObject(ctx, alioss.Bucket, path+"/", bytes.NewReader(nil))
 	if err != nil {
 		alioss.Log.Printf("Failed to create folder %s: %s\n", path, err)
 		return err
@@ -130,54 +150,51 @@ func (alioss AliOss) CreateFolder(path string) error {
 }
 
 // List files and folders.
-// SubFolder can be ""
-func (alioss AliOss) GetBucketFilesList(subFolder string) ([]oss.ObjectProperties, error) {
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed to list objects: %s\n", err)
-		return nil, err
-	}
+// SubFolder can be "", folders have only Key ending with "/". Filters of options select files.
+func (alioss AliOss) GetBucketFilesList(subFolder string, opts ...ListOption) ([]oss.ObjectProperties, error) {
+	return alioss.GetBucketFilesListContext(context.Background(), subFolder, opts...)
+}
 
+// Same as GetBucketFilesList with context
+func (alioss AliOss) GetBucketFilesListContext(ctx context.Context, subFolder string, opts ...ListOption) (list []oss.ObjectProperties, err error) {
 	subFolder = strings.TrimPrefix(subFolder, "/")
 	subFolder = strings.TrimSuffix(subFolder, "/")
 	if subFolder != "" {
 		subFolder = subFolder + "/"
 	}
-	result, err := bucket.ListObjects(oss.Prefix(subFolder), oss.Delimiter("/"))
-	if err != nil {
-		alioss.Log.Printf("Failed to list objects: %s\n", err)
-		return nil, err
+	cfg := newListConfig(subFolder, opts)
+	for page, err := range alioss.listPages(ctx, subFolder, "/") {
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Objects {
+			if cfg.matches(listedObjectInfo(object)) {
+				list = append(list, object)
+			}
+		}
+		for _, prefix := range page.CommonPrefixes {
+			list = append(list, oss.ObjectProperties{Key: prefix})
+		}
 	}
 
-	alioss.Log.Printf("Get bucket files in /%s:\n", subFolder, result.Objects)
-	return result.Objects, nil
+	alioss.Log.Printf("Get bucket files in /%s: %v\n", subFolder, list)
+	return list, nil
 }
 
 // Get file info
-// Returns HTTP headers
+// Returns HTTP headers, missing file is reported as ErrNotFound
 func (alioss AliOss) GetFileInfo(path string) (headers http.Header, err error) {
-	path = strings.TrimPrefix(path, "/")
-
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed to get file %s info: %s\n", path, err)
-		return
-	}
+	return alioss.GetFileInfoContext(context.Background(), path)
+}
 
-	isExist, err := bucket.IsObjectExist(path)
-	if err != nil {
-		alioss.Log.Printf("Failed to get file %s info: %s\n", path, err)
-		return
-	}
-	if !isExist {
-		alioss.Log.Printf("Failed to get file info: File does not exists: %s", path)
-		return
-	}
+// Same as GetFileInfo with context
+func (alioss AliOss) GetFileInfoContext(ctx context.Context, path string) (headers http.Header, err error) {
+	path = strings.TrimPrefix(path, "/")
 
-	headers, err = bucket.GetObjectDetailedMeta(path)
+	headers, err = alioss.backend().HeadObject(ctx, alioss.Bucket, path)
 	if err != nil {
 		alioss.Log.Printf("Failed to get file %s info: %s\n", path, err)
-		return
+		return nil, fmt.Errorf("Failed to get file %s info: %w", path, err)
 	}
 
 	alioss.Log.Println("Get file info:", path, headers)
@@ -186,15 +203,14 @@ func (alioss AliOss) GetFileInfo(path string) (headers http.Header, err error) {
 
 // Get file part
 func (alioss AliOss) GetFilePart(path string, start int64, end int64) (buf bytes.Buffer, err error) {
-	path = strings.TrimPrefix(path, "/")
+	return alioss.GetFilePartContext(context.Background(), path, start, end)
+}
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed to get file %s info: %s\n", path, err)
-		return
-	}
+// Same as GetFilePart with context
+func (alioss AliOss) GetFilePartContext(ctx context.Context, path string, start int64, end int64) (buf bytes.Buffer, err error) {
+	path = strings.TrimPrefix(path, "/")
 
-	resp, err := bucket.GetObject(path, oss.Range(start, end))
+	resp, err := alioss.backend().GetObject(ctx, alioss.Bucket, path, start, end, "")
 	if err != nil {
 		alioss.Log.Printf("Failed to get file %s part: %s\n", path, err)
 		return
@@ -213,15 +229,14 @@ func (alioss AliOss) GetFilePart(path string, start int64, end int64) (buf bytes
 
 // Delete file
 func (alioss AliOss) Delete(path string) (err error) {
-	path = strings.TrimPrefix(path, "/")
+	return alioss.DeleteContext(context.Background(), path)
+}
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed to get file %s info: %s\n", path, err)
-		return
-	}
+// Same as Delete with context
+func (alioss AliOss) DeleteContext(ctx context.Context, path string) (err error) {
+	path = strings.TrimPrefix(path, "/")
 
-	err = bucket.DeleteObject(path)
+	err = alioss.backend().DeleteObject(ctx, alioss.Bucket, path)
 	if err != nil {
 		alioss.Log.Println("Failed to delete:", path, err)
 		return
@@ -231,22 +246,94 @@ func (alioss AliOss) Delete(path string) (err error) {
 	return
 }
 
+// Delete all objects in folder "prefix" selected by filters of options, returns number of deleted objects.
+// Objects are deleted by batches of up to 1000 keys, WithListConcurrency sets number of parallel batches,
+// the first failure stops deletion.
+func (alioss AliOss) DeleteMatching(ctx context.Context, prefix string, opts ...ListOption) (int, error) {
+	prefix = folderPrefix(prefix)
+	cfg := newListConfig(prefix, opts)
+	cfg.recursive = true
+	group, deleteCtx := newPartGroup(ctx)
+
+	var deleted atomic.Int64
+	batches := make(chan []string, cfg.concurrency)
+	for i := 0; i < cfg.concurrency; i++ {
+		group.Go(func() error {
+			for keys := range batches {
+				if deleteCtx.Err() != nil {
+					continue // Drain batches listed before failure
+				}
+				err := alioss.backend().DeleteObjects(deleteCtx, alioss.Bucket, keys)
+				if err != nil {
+					alioss.Log.Printf("Failed to delete %d objects from %s: %s\n", len(keys), keys[0], err)
+					return fmt.Errorf("Failed to delete %d objects from %s: %w", len(keys), keys[0], err)
+				}
+				deleted.Add(int64(len(keys)))
+			}
+			return nil
+		})
+	}
+
+	group.Go(func() error {
+		defer close(batches)
+		send := func(keys []string) error {
+			select {
+			case batches <- keys:
+				return nil
+			case <-deleteCtx.Done():
+				return deleteCtx.Err()
+			}
+		}
+
+		var keys []string
+		for info, err := range alioss.list(deleteCtx, prefix, cfg) {
+			if err != nil {
+				return err
+			}
+			keys = append(keys, info.Key)
+			if len(keys) == maxDeleteKeys {
+				if err := send(keys); err != nil {
+					return err
+				}
+				keys = nil
+			}
+		}
+		if len(keys) > 0 {
+			return send(keys)
+		}
+		return nil
+	})
+
+	err := group.Wait()
+	alioss.Log.Printf("Deleted %d objects in /%s\n", deleted.Load(), prefix)
+	return int(deleted.Load()), err
+}
+
 // List bucket's unfinished uploads
 func (alioss AliOss) ListUnfinishedUploads() ([]oss.UncompletedUpload, error) {
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed list unfinised uploads: %s\n", err)
-		return nil, err
-	}
+	return alioss.ListUnfinishedUploadsContext(context.Background())
+}
 
-	resp, err := bucket.ListMultipartUploads()
-	if err != nil {
-		alioss.Log.Printf("Failed list unfinised uploads: %s\n", err)
-		return nil, err
+// Same as ListUnfinishedUploads with context
+func (alioss AliOss) ListUnfinishedUploadsContext(ctx context.Context) ([]oss.UncompletedUpload, error) {
+	var uploads []oss.UncompletedUpload
+	var keyMarker, uploadIdMarker string
+	for {
+		resp, err := alioss.backend().ListMultipartUploads(ctx, alioss.Bucket, "", keyMarker, uploadIdMarker)
+		if err != nil {
+			alioss.Log.Printf("Failed list unfinised uploads: %s\n", err)
+			return nil, err
+		}
+
+		uploads = append(uploads, resp.Uploads...)
+		if !resp.IsTruncated {
+			break
+		}
+		keyMarker, uploadIdMarker = resp.NextKeyMarker, resp.NextUploadIDMarker
 	}
 
-	alioss.Log.Println("List bucket's unfinished uploads", resp)
-	return resp.Uploads, nil
+	alioss.Log.Println("List bucket's unfinished uploads", uploads)
+	return uploads, nil
 }
 
 // List parts of unfinished uploads
@@ -255,47 +342,48 @@ func (alioss AliOss) ListUnfinishedUploads() ([]oss.UncompletedUpload, error) {
 // UploadedParts.PartNumber
 // UploadedParts.Size
 func (alioss AliOss) ListParts(key string, uploadId string) (resp oss.ListUploadedPartsResult, err error) {
+	return alioss.ListPartsContext(context.Background(), key, uploadId)
+}
+
+// Same as ListParts with context
+func (alioss AliOss) ListPartsContext(ctx context.Context, key string, uploadId string) (resp oss.ListUploadedPartsResult, err error) {
 	key = strings.TrimPrefix(key, "/")
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed list parts: %s\n", err)
-		return
-	}
+	partNumberMarker := 0
+	for {
+		var page oss.ListUploadedPartsResult
+		page, err = alioss.backend().ListUploadedParts(ctx, alioss.Bucket, key, uploadId, partNumberMarker)
+		if err != nil {
+			alioss.Log.Printf("Failed list parts: %s\n", err)
+			return
+		}
 
-	resp, err = bucket.ListUploadedParts(
-		oss.InitiateMultipartUploadResult{
-			Bucket:   alioss.Bucket,
-			Key:      key,
-			UploadID: uploadId,
-		},
-	)
-	if err != nil {
-		alioss.Log.Printf("Failed list parts: %s\n", err)
-		return
+		page.UploadedParts = append(resp.UploadedParts, page.UploadedParts...)
+		resp = page
+		if !page.IsTruncated {
+			break
+		}
+		partNumberMarker, err = strconv.Atoi(page.NextPartNumberMarker)
+		if err != nil {
+			alioss.Log.Printf("Failed list parts: Invalid next part number marker %q: %s\n", page.NextPartNumberMarker, err)
+			return
+		}
 	}
 
-	alioss.Log.Printf("List parts for key %s of upload id %s: %s\n", key, uploadId, resp)
+	alioss.Log.Printf("List parts for key %s of upload id %s: %v\n", key, uploadId, resp.UploadedParts)
 	return
 }
 
 // Abort upload
 func (alioss AliOss) AbortUpload(key string, uploadId string) (err error) {
-	key = strings.TrimPrefix(key, "/")
+	return alioss.AbortUploadContext(context.Background(), key, uploadId)
+}
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed abort upload: %s\n", err)
-		return
-	}
+// Same as AbortUpload with context
+func (alioss AliOss) AbortUploadContext(ctx context.Context, key string, uploadId string) (err error) {
+	key = strings.TrimPrefix(key, "/")
 
-	err = bucket.AbortMultipartUpload(
-		oss.InitiateMultipartUploadResult{
-			Bucket:   alioss.Bucket,
-			Key:      key,
-			UploadID: uploadId,
-		},
-	)
+	err = alioss.backend().AbortMultipartUpload(ctx, alioss.Bucket, key, uploadId)
 	if err != nil {
 		alioss.Log.Printf("Failed abort upload: %s\n", err)
 		return
@@ -307,20 +395,19 @@ func (alioss AliOss) AbortUpload(key string, uploadId string) (err error) {
 
 // Complete upload
 func (alioss AliOss) CompleteUpload(key string, uploadId string) (err error) {
+	return alioss.CompleteUploadContext(context.Background(), key, uploadId)
+}
+
+// Same as CompleteUpload with context
+func (alioss AliOss) CompleteUploadContext(ctx context.Context, key string, uploadId string) (err error) {
 	key = strings.TrimPrefix(key, "/")
 
-	respParts, err := alioss.ListParts(key, uploadId) // Just for debug
+	respParts, err := alioss.ListPartsContext(ctx, key, uploadId) // Just for debug
 	if err != nil {
 		alioss.Log.Printf("Failed to complete upload: Failed to list parts for key %s of upload id %s: %s\n", key, uploadId, err)
 		return
 	}
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed complete upload: %s\n", err)
-		return
-	}
-
 	var completedParts []oss.UploadPart
 	for _, part := range respParts.UploadedParts {
 		completedPart := oss.UploadPart{
@@ -329,19 +416,12 @@ func (alioss AliOss) CompleteUpload(key string, uploadId string) (err error) {
 		}
 		completedParts = append(completedParts, completedPart)
 	}
-	resp, err := bucket.CompleteMultipartUpload(
-		oss.InitiateMultipartUploadResult{
-			Bucket:   alioss.Bucket,
-			Key:      key,
-			UploadID: uploadId,
-		},
-		completedParts,
-	)
+	err = alioss.backend().CompleteMultipartUpload(ctx, alioss.Bucket, key, uploadId, completedParts)
 	if err != nil {
 		alioss.Log.Printf("Failed to complete upload for key %s of upload id %s: %s\n", key, uploadId, err)
 		return
 	}
-	alioss.Log.Printf("Complete upload for key %s of upload id %s: %s\n", key, uploadId, resp)
+	alioss.Log.Printf("Complete upload for key %s of upload id %s\n", key, uploadId)
 
 	return
 }
@@ -1,19 +1,34 @@
 package alioss
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/kardianos/osext"
+	"github.com/oneumyvakin/alioss/memoss"
+	"github.com/oneumyvakin/alioss/osstest"
+	"hash/crc64"
 	"io"
+	"io/fs"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
 	"time"
 )
 
@@ -27,25 +42,40 @@ func init() {
 	AliBucket = os.Getenv("ALI_BUCKET")
 	AliKeyId = os.Getenv("ALI_ACCESS_KEY_ID")
 	AliSecretKey = os.Getenv("ALI_SECRET_ACCESS_KEY")
-
 }
 
-func TestResumeUpload(t *testing.T) {
-	if AliRegion == "" || AliBucket == "" || AliKeyId == "" || AliSecretKey == "" {
-		t.Fatal("Environment variables ALI_REGION or ALI_BUCKET or ALI_ACCESS_KEY_ID or ALI_SECRET_ACCESS_KEY are not defined")
+// Get service for tests.
+// Tests run against local OSS emulator unless environment variables
+// ALI_REGION, ALI_BUCKET, ALI_ACCESS_KEY_ID and ALI_SECRET_ACCESS_KEY are defined.
+func newTestService(t *testing.T) (aliSvc AliOss, emulated bool) {
+	region, bucket, keyId, secretKey := AliRegion, AliBucket, AliKeyId, AliSecretKey
+	if region == "" || bucket == "" || keyId == "" || secretKey == "" {
+		server, err := osstest.NewServerWithBucket("test-bucket")
+		if err != nil {
+			t.Fatalf("Failed to start OSS emulator: %s", err)
+		}
+		t.Cleanup(server.Close)
+
+		region, bucket, keyId, secretKey = server.URL, "test-bucket", "test", "test"
+		emulated = true
 	}
 
-	ali, err := oss.New(AliRegion, AliKeyId, AliSecretKey)
+	ali, err := oss.New(region, keyId, secretKey)
 	if err != nil {
 		t.Fatalf("Failed to create OSS client: %s", err)
 	}
 
-	aliSvc := AliOss{
+	aliSvc = AliOss{
 		Log:    log.New(os.Stdout, "testing: ", log.LstdFlags),
 		Svc:    ali,
-		Region: AliRegion,
-		Bucket: AliBucket,
+		Region: region,
+		Bucket: bucket,
 	}
+	return
+}
+
+func TestResumeUpload(t *testing.T) {
+	aliSvc, _ := newTestService(t)
 
 	bucket, err := aliSvc.Svc.Bucket(aliSvc.Bucket)
 	if err != nil {
@@ -56,6 +86,9 @@ func TestResumeUpload(t *testing.T) {
 	testFileDownloaded := testFile + ".downloaded"
 
 	imur, err := bucket.InitiateMultipartUpload(filepath.Base(testFile))
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %s", err)
+	}
 
 	unfUploads, err := aliSvc.ListUnfinishedUploads()
 	if err != nil {
@@ -93,7 +126,7 @@ func TestResumeUpload(t *testing.T) {
 		t.Fatalf("Failed to get MD5 of %s: %s", testFile, err)
 	}
 
-	md5downloaded, err := md5sum(testFile)
+	md5downloaded, err := md5sum(testFileDownloaded)
 	if err != nil {
 		t.Fatalf("Failed to get MD5 of %s: %s", testFileDownloaded, err)
 	}
@@ -120,25 +153,13 @@ func TestResumeUpload(t *testing.T) {
 }
 
 func TestBasic(t *testing.T) {
-	if AliRegion == "" || AliBucket == "" || AliKeyId == "" || AliSecretKey == "" {
-		t.Fatal("Environment variables ALI_REGION or ALI_BUCKET or ALI_ACCESS_KEY_ID or ALI_SECRET_ACCESS_KEY are not defined")
-	}
+	aliSvc, emulated := newTestService(t)
 
-	ali, err := oss.New(AliRegion, AliKeyId, AliSecretKey)
-	if err != nil {
-		t.Fatalf("Failed to create OSS client: %s", err)
-	}
-
-	aliSvc := AliOss{
-		Log:    log.New(os.Stdout, "testing: ", log.LstdFlags),
-		Svc:    ali,
-		Region: AliRegion,
-		Bucket: AliBucket,
-	}
-
-	err = aliSvc.IsRegionValid(AliRegion)
-	if err != nil {
-		return
+	if !emulated {
+		err := aliSvc.IsRegionValid(aliSvc.Region)
+		if err != nil {
+			return
+		}
 	}
 
 	bucketList, err := aliSvc.GetBucketFilesList("")
@@ -215,6 +236,747 @@ func TestBasic(t *testing.T) {
 	}
 }
 
+// Get service over in-memory backend with bucket "test-bucket"
+func newMemoryService() (AliOss, *memoss.Backend) {
+	backend := memoss.New()
+	_ = backend.CreateBucket(context.Background(), "test-bucket")
+	return AliOss{
+		Log:     log.New(io.Discard, "", 0),
+		Backend: backend,
+		Bucket:  "test-bucket",
+	}, backend
+}
+
+func TestTransferOptions(t *testing.T) {
+	aliSvc, backend := newMemoryService()
+	aliSvc.Transfer.PartSize = 1024
+
+	testFile := createTestFile(MinUploadPartSize*3 + 100)
+	defer os.Remove(testFile)
+
+	err := aliSvc.Upload(testFile, "")
+	if err == nil {
+		t.Fatal("Failed to reject part size below OSS minimum")
+	}
+
+	checkpointDir := t.TempDir()
+	err = aliSvc.Upload(testFile, "", WithPartSize(MinUploadPartSize), WithConcurrency(2), WithBufferPool(), WithCheckpointDir(checkpointDir))
+	if err != nil {
+		t.Fatalf("Failed to upload with options: %s", err)
+	}
+
+	data, _ := backend.Object("test-bucket", filepath.Base(testFile))
+	expected, _ := os.ReadFile(testFile)
+	if string(data) != string(expected) {
+		t.Fatal("Failed to match uploaded data")
+	}
+	if entries, _ := os.ReadDir(checkpointDir); len(entries) != 0 {
+		t.Fatalf("Failed to remove checkpoint: %v", entries)
+	}
+}
+
+func TestPartSize(t *testing.T) {
+	size := int64(100) * 1024 * 1024 * 1024 // 100Gb
+	cfg := TransferConfig{Concurrency: 1}.forSize(size)
+	if err := cfg.validateUpload(size); err != nil {
+		t.Fatalf("Failed to choose part size for %d bytes: %s", size, err)
+	}
+	if cfg = (TransferConfig{Concurrency: 1}).forSize(1024); cfg.PartSize != DefaultUploadPartSize {
+		t.Fatalf("Failed to choose default part size for small file: %d", cfg.PartSize)
+	}
+
+	cfg = TransferConfig{}.forSize(-1)
+	var streamed int64
+	for partNumber := 1; partNumber <= MaxUploadPartCount; partNumber++ {
+		partSize := cfg.partSize(partNumber, -1)
+		if partSize > MaxUploadPartSize || partSize < cfg.PartSize {
+			t.Fatalf("Invalid size %d of part number %d", partSize, partNumber)
+		}
+		streamed += partSize
+	}
+	if streamed < size*10 {
+		t.Fatalf("Failed to fit 1Tb stream into %d parts: %d bytes", MaxUploadPartCount, streamed)
+	}
+
+	aliSvc, backend := newMemoryService()
+	testFile := createTestFile(MinUploadPartSize * 2)
+	defer os.Remove(testFile)
+	err := aliSvc.Upload(testFile, "", WithPartSize(MinUploadPartSize))
+	if err != nil {
+		t.Fatalf("Failed to upload file of whole parts: %s", err)
+	}
+	headers, _ := backend.HeadObject(context.Background(), "test-bucket", filepath.Base(testFile))
+	if etag := headers.Get("ETag"); !strings.HasSuffix(etag, "-2\"") {
+		t.Fatalf("Failed to upload file of whole parts in 2 parts: %s", etag)
+	}
+}
+
+func TestUploadReader(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+
+	data := []byte(getRandomString(int(MinUploadPartSize*3 + 100)))
+	for _, size := range []int{0, 100, len(data)} {
+		key := fmt.Sprintf("reader/%d.bin", size)
+		err := aliSvc.UploadReader(ctx, "/"+key, iotest.OneByteReader(bytes.NewReader(data[:size])), WithPartSize(MinUploadPartSize)) // Leading "/" is trimmed
+		if err != nil {
+			t.Fatalf("Failed to upload reader of %d bytes: %s", size, err)
+		}
+		if uploaded, _ := backend.Object("test-bucket", key); !bytes.Equal(uploaded, data[:size]) {
+			t.Fatalf("Failed to match uploaded data of %d bytes", size)
+		}
+	}
+
+	reader := io.MultiReader(bytes.NewReader(data), iotest.ErrReader(errors.New("broken pipe")))
+	err := aliSvc.UploadReader(ctx, "reader/broken.bin", reader, WithPartSize(MinUploadPartSize))
+	if err == nil || !strings.Contains(err.Error(), "broken pipe") {
+		t.Fatalf("Failed to report read error: %v", err)
+	}
+	if uploads, _ := aliSvc.ListUnfinishedUploads(); len(uploads) != 0 {
+		t.Fatalf("Failed to abort upload: %v", uploads)
+	}
+}
+
+// Backend which fails abort of uploads
+type abortFailingBackend struct {
+	*memoss.Backend
+}
+
+func (b abortFailingBackend) AbortMultipartUpload(ctx context.Context, bucket, key, uploadId string) error {
+	return errors.New("abort is broken")
+}
+
+func TestWriter(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	data := []byte(getRandomString(int(MinUploadPartSize*3 + 100)))
+
+	w := aliSvc.NewWriter(ctx, "/writer/data.bin", WithPartSize(MinUploadPartSize), WithConcurrency(2)) // Leading "/" is trimmed
+	for i := 0; i < len(data); i += 1000 {
+		if _, err := w.Write(data[i:min(i+1000, len(data))]); err != nil {
+			t.Fatalf("Failed to write at %d: %s", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %s", err)
+	}
+	if uploaded, _ := backend.Object("test-bucket", "writer/data.bin"); !bytes.Equal(uploaded, data) {
+		t.Fatal("Failed to match written data")
+	}
+
+	w = aliSvc.NewWriter(ctx, "writer/aborted.bin", WithPartSize(MinUploadPartSize))
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := w.CloseWithError(errors.New("encoder failed")); err != nil {
+		t.Fatalf("Failed to abort writer: %s", err)
+	}
+	if _, ok := backend.Object("test-bucket", "writer/aborted.bin"); ok {
+		t.Fatal("Failed to abort written object")
+	}
+	if uploads, _ := aliSvc.ListUnfinishedUploads(); len(uploads) != 0 {
+		t.Fatalf("Failed to abort upload: %v", uploads)
+	}
+
+	w = aliSvc.NewWriter(ctx, "writer/eof.bin", WithPartSize(MinUploadPartSize))
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := w.CloseWithError(io.EOF); err != nil {
+		t.Fatalf("Failed to abort writer by EOF: %s", err)
+	}
+	if _, ok := backend.Object("test-bucket", "writer/eof.bin"); ok {
+		t.Fatal("Failed to abort upload closed by EOF")
+	}
+
+	aliSvc.Backend = abortFailingBackend{backend}
+	w = aliSvc.NewWriter(ctx, "writer/stuck.bin", WithPartSize(MinUploadPartSize))
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := w.CloseWithError(errors.New("encoder failed")); err == nil {
+		t.Fatal("Failed to report failed abort")
+	}
+}
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	data := []byte(getRandomString(1000))
+	_ = backend.PutObject(ctx, "test-bucket", "dir/stat", bytes.NewReader(data))
+
+	info, err := aliSvc.Stat("/dir/stat")
+	if err != nil {
+		t.Fatalf("Failed to stat: %s", err)
+	}
+	if info.Key != "dir/stat" || info.Size != int64(len(data)) || info.ETag != fmt.Sprintf("\"%X\"", md5.Sum(data)) ||
+		info.LastModified.IsZero() || info.StorageClass != "Standard" || info.ObjectType != "Normal" ||
+		info.CRC64 != crc64.Checksum(data, crc64.MakeTable(crc64.ECMA)) {
+		t.Fatalf("Failed to match object info: %+v", info)
+	}
+
+	if _, err = aliSvc.Stat("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Failed to report missing key: %v", err)
+	}
+	if headers, err := aliSvc.GetFileInfo("missing"); headers != nil || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Failed to report missing file: %v", err)
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Length", "10")
+	headers.Set("X-Oss-Meta-Author", "someone")
+	headers.Set("X-Oss-Version-Id", "v1")
+	info, err = objectInfo("meta", headers)
+	if err != nil || info.Metadata["author"] != "someone" || info.VersionID != "v1" {
+		t.Fatalf("Failed to get metadata: %+v %v", info, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, _ := newMemoryService()
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	data := []byte(getRandomString(10000))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		fw, _ := zw.Create(name)
+		_, _ = fw.Write(data)
+	}
+	_ = zw.Close()
+	if err := aliSvc.UploadReader(ctx, "archive.zip", bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Failed to upload archive: %s", err)
+	}
+
+	r, err := aliSvc.Open(ctx, "archive.zip", WithPartSize(1000))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %s", err)
+	}
+	if err := iotest.TestReader(r, archive.Bytes()); err != nil {
+		t.Fatalf("Failed to read archive: %s", err)
+	}
+
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		t.Fatalf("Failed to read zip directory: %s", err)
+	}
+	for _, f := range zr.File {
+		fr, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s in archive: %s", f.Name, err)
+		}
+		content, err := io.ReadAll(fr)
+		if err != nil || !bytes.Equal(content, data) {
+			t.Fatalf("Failed to read %s from archive: %s", f.Name, err)
+		}
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Failed to close reader: %s", err)
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("Failed to fail read of closed reader: %v", err)
+	}
+	if _, err := aliSvc.Open(ctx, "missing.zip"); err == nil {
+		t.Fatal("Failed to report missing object")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, _ := newMemoryService()
+
+	data := []byte(getRandomString(3*1000 + 17))
+	if err := aliSvc.UploadReader(ctx, "folder/data.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Failed to upload data: %s", err)
+	}
+
+	dir := t.TempDir()
+	destination := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(destination, []byte("previous content"), 0644); err != nil {
+		t.Fatalf("Failed to write previous content: %s", err)
+	}
+	err := aliSvc.Download("/folder/data.bin", destination, WithPartSize(1000), WithConcurrency(3))
+	if err != nil {
+		t.Fatalf("Failed to download: %s", err)
+	}
+	if downloaded, _ := os.ReadFile(destination); !bytes.Equal(downloaded, data) {
+		t.Fatal("Failed to match downloaded data")
+	}
+
+	err = aliSvc.Download("folder/missing.bin", filepath.Join(dir, "missing.bin"))
+	if err == nil {
+		t.Fatal("Failed to report missing object")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("Failed to clean up temporary files: %v", entries)
+	}
+}
+
+func TestDownloadWriter(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, _ := newMemoryService()
+
+	data := []byte(getRandomString(10*1000 + 17))
+	if err := aliSvc.UploadReader(ctx, "data.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Failed to upload data: %s", err)
+	}
+
+	var buf bytes.Buffer
+	err := aliSvc.DownloadWriter(ctx, "data.bin", &buf, WithPartSize(1000), WithConcurrency(4))
+	if err != nil {
+		t.Fatalf("Failed to download: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("Failed to match downloaded data")
+	}
+
+	buf.Reset()
+	ordered := newOrderedWriter(&buf, 100)
+	var wg sync.WaitGroup
+	for _, off := range rand.Perm(len(data) / 100) {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			_, _ = ordered.WriteAt(data[off:off+100], off)
+		}(int64(off) * 100)
+	}
+	wg.Wait()
+	if !bytes.Equal(buf.Bytes(), data[:len(data)/100*100]) {
+		t.Fatal("Failed to reorder parts")
+	}
+
+	ordered = newOrderedWriter(&buf, 100)
+	_, _ = ordered.WriteAt(data[100:200], 100)
+	go ordered.fail(errors.New("part failed"))
+	if _, err := ordered.WriteAt(data[200:300], 200); err == nil {
+		t.Fatal("Failed to stop waiting write")
+	}
+}
+
+func TestResumeDownloadJournal(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+
+	data := []byte(getRandomString(5000))
+	if err := aliSvc.UploadReader(ctx, "data.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Failed to upload data: %s", err)
+	}
+	headers, _ := backend.HeadObject(ctx, "test-bucket", "data.bin")
+
+	destination := filepath.Join(t.TempDir(), "data.bin")
+	journal := &downloadJournal{
+		path:         destination + journalSuffix,
+		ETag:         headers.Get("ETag"),
+		LastModified: headers.Get("Last-Modified"),
+		Size:         int64(len(data)),
+		PartSize:     1000,
+	}
+	journal.add(2000, 1000)
+	if err := journal.save(); err != nil {
+		t.Fatalf("Failed to save journal: %s", err)
+	}
+
+	// Downloaded range is kept as is, the rest is downloaded
+	partial := make([]byte, 3000)
+	copy(partial[2000:], "downloaded range")
+	if err := os.WriteFile(destination, partial, 0644); err != nil {
+		t.Fatalf("Failed to write partial download: %s", err)
+	}
+	if err := aliSvc.ResumeDownload("data.bin", destination, WithPartSize(700)); err != nil {
+		t.Fatalf("Failed to resume download: %s", err)
+	}
+	downloaded, _ := os.ReadFile(destination)
+	expected := append(append(append([]byte(nil), data[:2000]...), partial[2000:]...), data[3000:]...)
+	if !bytes.Equal(downloaded, expected) {
+		t.Fatal("Failed to download exactly missing ranges")
+	}
+	if _, err := os.Stat(journal.path); !os.IsNotExist(err) {
+		t.Fatalf("Failed to remove journal: %v", err)
+	}
+
+	// Journal of other version of remote file restarts download
+	journal.ETag = "\"outdated\""
+	if err := journal.save(); err != nil {
+		t.Fatalf("Failed to save journal: %s", err)
+	}
+	if err := os.WriteFile(destination, make([]byte, 6000), 0644); err != nil {
+		t.Fatalf("Failed to write outdated download: %s", err)
+	}
+	if err := aliSvc.ResumeDownload("data.bin", destination); err != nil {
+		t.Fatalf("Failed to restart download: %s", err)
+	}
+	if downloaded, _ := os.ReadFile(destination); !bytes.Equal(downloaded, data) {
+		t.Fatal("Failed to restart download of changed file")
+	}
+}
+
+func TestResumeUploadAuto(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+
+	testFile := createTestFile(MinUploadPartSize*3 + 100)
+	defer os.Remove(testFile)
+	data, _ := os.ReadFile(testFile)
+	key := filepath.Base(testFile)
+
+	matching, _ := backend.InitiateMultipartUpload(ctx, "test-bucket", key)
+	_, _ = backend.UploadPart(ctx, "test-bucket", key, matching, 1, bytes.NewReader(data[:MinUploadPartSize]), MinUploadPartSize)
+	stale, _ := backend.InitiateMultipartUpload(ctx, "test-bucket", key)
+	garbage := make([]byte, MinUploadPartSize)
+	_, _ = backend.UploadPart(ctx, "test-bucket", key, stale, 1, bytes.NewReader(garbage), MinUploadPartSize)
+
+	var logs bytes.Buffer
+	aliSvc.Log = log.New(&logs, "", 0)
+	err := aliSvc.ResumeUploadAuto(testFile, key, WithPartSize(MinUploadPartSize))
+	if err != nil {
+		t.Fatalf("Failed to resume upload: %s", err)
+	}
+	if matches := strings.Count(logs.String(), "Match Etag for part number 1 "); matches != 1 { // Part verified on search isn't read again
+		t.Fatalf("Failed to reuse verified part: verified %d times", matches)
+	}
+	if uploaded, _ := backend.Object("test-bucket", key); !bytes.Equal(uploaded, data) {
+		t.Fatal("Failed to match uploaded data")
+	}
+	uploads, _ := aliSvc.ListUnfinishedUploads()
+	if len(uploads) != 1 || uploads[0].UploadID != stale {
+		t.Fatalf("Failed to resume matching upload %s: %v", matching, uploads)
+	}
+
+	err = aliSvc.ResumeUploadAuto(testFile, "/other/"+key, WithPartSize(MinUploadPartSize))
+	if err != nil {
+		t.Fatalf("Failed to upload without unfinished upload: %s", err)
+	}
+	if uploaded, _ := backend.Object("test-bucket", "other/"+key); !bytes.Equal(uploaded, data) {
+		t.Fatal("Failed to match new upload data")
+	}
+}
+
+// Backend which counts uploaded parts
+type countingBackend struct {
+	*memoss.Backend
+	parts *atomic.Int32
+}
+
+func (b countingBackend) UploadPart(ctx context.Context, bucket, key, uploadId string, partNumber int, reader io.Reader, size int64) (oss.UploadPart, error) {
+	b.parts.Add(1)
+	return b.Backend.UploadPart(ctx, bucket, key, uploadId, partNumber, reader, size)
+}
+
+func TestResumeUploadSkipsParts(t *testing.T) {
+	ctx := context.Background()
+	testFile := createTestFile(MinUploadPartSize*5 + 100)
+	defer os.Remove(testFile)
+	data, _ := os.ReadFile(testFile)
+	stat, _ := os.Stat(testFile)
+	key := filepath.Base(testFile)
+
+	tests := []struct {
+		name          string
+		checkpoint    bool // Uploaded parts are recorded in checkpoint
+		verify        bool
+		expectedParts int32
+	}{
+		{name: "unknown parts", expectedParts: 3},                                           // Mismatched part 3 and missing parts 5 and 6
+		{name: "checkpoint parts", checkpoint: true, expectedParts: 2},                      // Missing parts 5 and 6, recorded parts aren't read
+		{name: "verify checkpoint parts", checkpoint: true, verify: true, expectedParts: 3}, // Mismatched part 3 and missing parts 5 and 6
+	}
+	for _, test := range tests {
+		aliSvc, backend := newMemoryService()
+		parts := &atomic.Int32{}
+		aliSvc.Backend = countingBackend{Backend: backend, parts: parts}
+
+		uploadId, _ := backend.InitiateMultipartUpload(ctx, "test-bucket", key)
+		checkpoint := &UploadCheckpoint{
+			Bucket:   "test-bucket",
+			Key:      key,
+			UploadId: uploadId,
+			Size:     stat.Size(),
+			ModTime:  stat.ModTime(),
+			PartSize: MinUploadPartSize,
+			Parts:    make(map[int]string),
+		}
+		for partNumber := 1; partNumber <= 4; partNumber++ {
+			part := data[(partNumber-1)*int(MinUploadPartSize) : partNumber*int(MinUploadPartSize)]
+			if partNumber == 3 {
+				part = []byte(getRandomString(int(MinUploadPartSize)))
+			}
+			uploaded, _ := backend.UploadPart(ctx, "test-bucket", key, uploadId, partNumber, bytes.NewReader(part), MinUploadPartSize)
+			checkpoint.Parts[partNumber] = uploaded.ETag
+		}
+
+		store := NewMemoryCheckpointStore()
+		opts := []TransferOption{WithPartSize(MinUploadPartSize), WithCheckpointStore(store)}
+		if test.checkpoint {
+			_, checkpointId := TransferConfig{Checkpoints: store}.checkpointStore(testFile, "test-bucket", key)
+			_ = store.Save(checkpointId, checkpoint)
+		}
+		if test.verify {
+			opts = append(opts, WithVerifyParts())
+		}
+		err := aliSvc.ResumeUpload(testFile, key, uploadId, opts...)
+		if err != nil {
+			t.Fatalf("Failed to resume upload with %s: %s", test.name, err)
+		}
+		if parts.Load() != test.expectedParts {
+			t.Fatalf("Failed to skip parts with %s: %d parts uploaded", test.name, parts.Load())
+		}
+		if uploaded, _ := backend.Object("test-bucket", key); !test.checkpoint || test.verify {
+			if !bytes.Equal(uploaded, data) {
+				t.Fatalf("Failed to match uploaded data with %s", test.name)
+			}
+		}
+	}
+}
+
+// Backend which cancels context on first uploaded part
+type cancelingBackend struct {
+	*memoss.Backend
+	cancel context.CancelFunc
+}
+
+func (b cancelingBackend) UploadPart(ctx context.Context, bucket, key, uploadId string, partNumber int, reader io.Reader, size int64) (oss.UploadPart, error) {
+	b.cancel()
+	return b.Backend.UploadPart(ctx, bucket, key, uploadId, partNumber, reader, size)
+}
+
+func TestResumeUploadCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aliSvc, backend := newMemoryService()
+	aliSvc.Backend = cancelingBackend{Backend: backend, cancel: cancel}
+
+	testFile := createTestFile(3 * DefaultUploadPartSize)
+	defer os.Remove(testFile)
+	_, checkpointId := TransferConfig{}.checkpointStore(testFile, "test-bucket", filepath.Base(testFile))
+	defer os.Remove(filepath.Join(filepath.Dir(testFile), checkpointId+".cp"))
+
+	uploadId, err := backend.InitiateMultipartUpload(ctx, "test-bucket", filepath.Base(testFile))
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %s", err)
+	}
+
+	err = aliSvc.ResumeUploadContext(ctx, testFile, filepath.Base(testFile), uploadId)
+	if err != context.Canceled {
+		t.Fatalf("Failed to cancel upload: %v", err)
+	}
+}
+
+// Backend which cancels context after first uploaded part
+type cancelingAfterBackend struct {
+	*memoss.Backend
+	cancel context.CancelFunc
+}
+
+func (b cancelingAfterBackend) UploadPart(ctx context.Context, bucket, key, uploadId string, partNumber int, reader io.Reader, size int64) (oss.UploadPart, error) {
+	defer b.cancel()
+	return b.Backend.UploadPart(ctx, bucket, key, uploadId, partNumber, reader, size)
+}
+
+func TestResumeUploadCancelAfterPart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aliSvc, backend := newMemoryService()
+	aliSvc.Backend = cancelingAfterBackend{Backend: backend, cancel: cancel}
+	testFile := createTestFile(MinUploadPartSize*3 + 100)
+	defer os.Remove(testFile)
+	key := filepath.Base(testFile)
+	store := NewMemoryCheckpointStore()
+
+	uploadId, _ := backend.InitiateMultipartUpload(ctx, "test-bucket", key)
+	err := aliSvc.ResumeUploadContext(ctx, testFile, key, uploadId, WithPartSize(MinUploadPartSize), WithConcurrency(1), WithCheckpointStore(store))
+	if err != context.Canceled {
+		t.Fatalf("Failed to cancel upload: %v", err)
+	}
+
+	_, checkpointId := TransferConfig{Checkpoints: store}.checkpointStore(testFile, "test-bucket", key)
+	checkpoint, _ := store.Load(checkpointId)
+	parts, _ := backend.ListUploadedParts(context.Background(), "test-bucket", key, uploadId, 0)
+	if checkpoint == nil || len(parts.UploadedParts) == 0 || len(checkpoint.Parts) != len(parts.UploadedParts) {
+		t.Fatalf("Failed to record parts uploaded before cancel: %v of %v", checkpoint, parts.UploadedParts)
+	}
+}
+
+func TestUploadCheckpointPerKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	aliSvc, backend := newMemoryService()
+	aliSvc.Backend = cancelingBackend{Backend: backend, cancel: cancel}
+	testFile := createTestFile(3 * DefaultUploadPartSize)
+	defer os.Remove(testFile)
+	for _, folder := range []string{"x", "y"} { // Upload puts file into folder
+		_, checkpointId := TransferConfig{}.checkpointStore(testFile, "test-bucket", folder+"/"+filepath.Base(testFile))
+		defer os.Remove(filepath.Join(filepath.Dir(testFile), checkpointId+".cp"))
+	}
+
+	if err := aliSvc.UploadContext(ctx, testFile, "x"); err == nil {
+		t.Fatal("Failed to interrupt upload")
+	}
+	aliSvc.Backend = backend
+	if err := aliSvc.Upload(testFile, "y"); err != nil {
+		t.Fatalf("Failed to upload to other key: %s", err)
+	}
+	if uploads, _ := aliSvc.ListUnfinishedUploads(); len(uploads) != 1 || uploads[0].Key != "x/"+filepath.Base(testFile) {
+		t.Fatalf("Failed to keep interrupted upload of other key: %v", uploads)
+	}
+
+	if err := aliSvc.Upload(testFile, "x"); err != nil {
+		t.Fatalf("Failed to continue upload: %s", err)
+	}
+	if uploads, _ := aliSvc.ListUnfinishedUploads(); len(uploads) != 0 {
+		t.Fatalf("Failed to finish interrupted upload: %v", uploads)
+	}
+}
+
+func TestCheckpointStores(t *testing.T) {
+	dir := t.TempDir()
+	stores := map[string]CheckpointStore{
+		"dir":    NewDirCheckpointStore(filepath.Join(dir, "checkpoints")),
+		"file":   NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json")),
+		"memory": NewMemoryCheckpointStore(),
+	}
+	for name, store := range stores {
+		if checkpoint, err := store.Load("missing"); checkpoint != nil || err != nil {
+			t.Fatalf("Failed to load missing checkpoint from %s store: %v %v", name, checkpoint, err)
+		}
+
+		saved := &UploadCheckpoint{Key: "key", UploadId: "id", PartSize: 100, Parts: map[int]string{1: "\"etag\""}}
+		if err := store.Save("id", saved); err != nil {
+			t.Fatalf("Failed to save checkpoint to %s store: %s", name, err)
+		}
+		loaded, err := store.Load("id")
+		if err != nil || loaded == nil || loaded.UploadId != "id" || loaded.Parts[1] != "\"etag\"" {
+			t.Fatalf("Failed to load checkpoint from %s store: %v %v", name, loaded, err)
+		}
+
+		if err := store.Delete("id"); err != nil {
+			t.Fatalf("Failed to delete checkpoint from %s store: %s", name, err)
+		}
+		if checkpoint, _ := store.Load("id"); checkpoint != nil {
+			t.Fatalf("Failed to delete checkpoint from %s store", name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	aliSvc, backend := newMemoryService()
+	aliSvc.Backend = cancelingBackend{Backend: backend, cancel: cancel}
+	store := NewMemoryCheckpointStore()
+
+	testFile := createTestFile(MinUploadPartSize*3 + 100)
+	defer os.Remove(testFile)
+	err := aliSvc.UploadContext(ctx, testFile, "", WithPartSize(MinUploadPartSize), WithCheckpointStore(store))
+	if err == nil {
+		t.Fatal("Failed to cancel upload")
+	}
+
+	_, checkpointId := aliSvc.uploadConfig([]TransferOption{WithCheckpointStore(store)}).checkpointStore(testFile, "test-bucket", filepath.Base(testFile))
+	checkpoint, _ := store.Load(checkpointId)
+	if checkpoint == nil || checkpoint.UploadId == "" || checkpoint.PartSize != MinUploadPartSize {
+		t.Fatalf("Failed to save checkpoint of interrupted upload: %v", checkpoint)
+	}
+
+	aliSvc.Backend = backend
+	err = aliSvc.Upload(testFile, "", WithPartSize(MinUploadPartSize), WithCheckpointStore(store))
+	if err != nil {
+		t.Fatalf("Failed to continue upload: %s", err)
+	}
+	if uploads, _ := aliSvc.ListUnfinishedUploads(); len(uploads) != 0 {
+		t.Fatalf("Failed to continue upload id %s: %v", checkpoint.UploadId, uploads)
+	}
+	if checkpoint, _ := store.Load(checkpointId); checkpoint != nil {
+		t.Fatal("Failed to delete checkpoint of finished upload")
+	}
+}
+
+// Store of checkpoints which counts saves
+type countingStore struct {
+	*MemoryCheckpointStore
+	saves *atomic.Int32
+}
+
+func (store countingStore) Save(id string, checkpoint *UploadCheckpoint) error {
+	store.saves.Add(1)
+	return store.MemoryCheckpointStore.Save(id, checkpoint)
+}
+
+func TestCheckpointSaver(t *testing.T) {
+	store := countingStore{MemoryCheckpointStore: NewMemoryCheckpointStore(), saves: &atomic.Int32{}}
+	checkpoint := &UploadCheckpoint{UploadId: "id", Parts: make(map[int]string)}
+	saver := newCheckpointSaver(store, "id", checkpoint, log.New(io.Discard, "", 0), time.Hour)
+
+	var wg sync.WaitGroup
+	for partNumber := 1; partNumber <= 100; partNumber++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			saver.addPart(oss.UploadPart{PartNumber: partNumber, ETag: fmt.Sprintf("\"%d\"", partNumber)})
+		}()
+	}
+	wg.Wait()
+	saver.Stop()
+
+	if store.saves.Load() > 2 {
+		t.Fatalf("Failed to throttle saves: %d saves", store.saves.Load())
+	}
+	if saved, _ := store.Load("id"); saved == nil || len(saved.Parts) != 100 {
+		t.Fatalf("Failed to save all parts on stop: %v", saved)
+	}
+}
+
+// Backend which overwrites object on first ranged request
+type changingBackend struct {
+	*memoss.Backend
+	once *sync.Once
+}
+
+func (b changingBackend) GetObject(ctx context.Context, bucket, key string, start, end int64, ifMatch string) (io.ReadCloser, error) {
+	b.once.Do(func() {
+		_ = b.Backend.PutObject(ctx, bucket, key, strings.NewReader(getRandomString(5000)))
+	})
+	return b.Backend.GetObject(ctx, bucket, key, start, end, ifMatch)
+}
+
+func TestObjectChanged(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	if err := aliSvc.UploadReader(ctx, "data.bin", strings.NewReader(getRandomString(5000))); err != nil {
+		t.Fatalf("Failed to upload data: %s", err)
+	}
+
+	destination := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(destination, nil, 0644); err != nil {
+		t.Fatalf("Failed to create destination: %s", err)
+	}
+	aliSvc.Backend = changingBackend{Backend: backend, once: &sync.Once{}}
+	err := aliSvc.ResumeDownload("data.bin", destination, WithPartSize(1000))
+	if !errors.Is(err, ErrObjectChanged) {
+		t.Fatalf("Failed to detect changed object: %v", err)
+	}
+
+	// Next resume starts from scratch
+	aliSvc.Backend = backend
+	if err := aliSvc.ResumeDownload("data.bin", destination, WithPartSize(1000)); err != nil {
+		t.Fatalf("Failed to restart download: %s", err)
+	}
+	data, _ := backend.Object("test-bucket", "data.bin")
+	if downloaded, _ := os.ReadFile(destination); !bytes.Equal(downloaded, data) {
+		t.Fatal("Failed to match restarted download")
+	}
+
+	r, err := aliSvc.Open(ctx, "data.bin")
+	if err != nil {
+		t.Fatalf("Failed to open object: %s", err)
+	}
+	_ = backend.PutObject(ctx, "test-bucket", "data.bin", strings.NewReader("new content"))
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, ErrObjectChanged) {
+		t.Fatalf("Failed to detect changed object on read: %v", err)
+	}
+}
+
 func createTestFile(size int64) string {
 	binaryDir, err := osext.ExecutableFolder()
 	if err != nil {
@@ -308,3 +1070,449 @@ func md5sum(filePath string) (result string, err error) {
 	result = hex.EncodeToString(hash.Sum(nil))
 	return
 }
+
+// Backend which fails upload of part 3 and download of range at "failOffset"
+type failingBackend struct {
+	*memoss.Backend
+	failOffset int64
+}
+
+func (b failingBackend) UploadPart(ctx context.Context, bucket, key, uploadId string, partNumber int, reader io.Reader, size int64) (oss.UploadPart, error) {
+	if partNumber == 3 {
+		return oss.UploadPart{}, errors.New("part is broken")
+	}
+	return b.Backend.UploadPart(ctx, bucket, key, uploadId, partNumber, reader, size)
+}
+
+func (b failingBackend) GetObject(ctx context.Context, bucket, key string, start, end int64, ifMatch string) (io.ReadCloser, error) {
+	if start == b.failOffset {
+		return nil, errors.New("range is broken")
+	}
+	return b.Backend.GetObject(ctx, bucket, key, start, end, ifMatch)
+}
+
+func TestFailedParts(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	aliSvc.Backend = failingBackend{Backend: backend, failOffset: MinUploadPartSize}
+
+	data := []byte(getRandomString(int(MinUploadPartSize)*8 + 100))
+	opts := []TransferOption{WithPartSize(MinUploadPartSize), WithConcurrency(2), WithRetries(-1), WithBufferPool()}
+	err := aliSvc.UploadReader(ctx, "failed", bytes.NewReader(data), opts...)
+	var partsErr PartsError
+	if !errors.As(err, &partsErr) || len(partsErr) != 1 || partsErr[0].PartNumber != 3 {
+		t.Fatalf("Failed to report failed upload part: %v", err)
+	}
+	if uploads, _ := aliSvc.ListUnfinishedUploads(); len(uploads) != 0 {
+		t.Fatalf("Failed to abort upload: %v", uploads)
+	}
+
+	_ = backend.PutObject(ctx, "test-bucket", "failed", bytes.NewReader(data))
+	err = aliSvc.DownloadWriter(ctx, "failed", io.Discard, opts...)
+	var partErr *PartError
+	if !errors.As(err, &partErr) || partErr.PartNumber != 2 {
+		t.Fatalf("Failed to report failed download part: %v", err)
+	}
+}
+
+// Backend which fails first "failures" requests of object metadata with "err"
+type flakyBackend struct {
+	*memoss.Backend
+	failures *atomic.Int32
+	err      error
+}
+
+func (b flakyBackend) HeadObject(ctx context.Context, bucket, key string) (http.Header, error) {
+	if b.failures.Add(-1) >= 0 {
+		return nil, b.err
+	}
+	return b.Backend.HeadObject(ctx, bucket, key)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	retryable := map[error]bool{
+		oss.ServiceError{StatusCode: http.StatusServiceUnavailable}:            true,
+		oss.ServiceError{StatusCode: http.StatusForbidden, Code: "Throttling"}: true,
+		oss.ServiceError{StatusCode: http.StatusNotFound, Code: "NoSuchKey"}:   false,
+		fmt.Errorf("read: %w", syscall.ECONNRESET):                             true,
+		context.Canceled:               false,
+		errors.New("invalid argument"): false,
+	}
+	for err, expected := range retryable {
+		if IsRetryable(err) != expected {
+			t.Fatalf("Failed to classify error %v as retryable %t", err, expected)
+		}
+	}
+
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	_ = backend.PutObject(ctx, "test-bucket", "retried", strings.NewReader("data"))
+	aliSvc.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	failures := &atomic.Int32{}
+	aliSvc.Backend = flakyBackend{Backend: backend, failures: failures, err: oss.ServiceError{StatusCode: http.StatusInternalServerError}}
+	failures.Store(2)
+	if _, err := aliSvc.backend().HeadObject(ctx, "test-bucket", "retried"); err != nil {
+		t.Fatalf("Failed to retry server error: %s", err)
+	}
+	failures.Store(3)
+	if _, err := aliSvc.backend().HeadObject(ctx, "test-bucket", "retried"); err == nil {
+		t.Fatal("Failed to stop after max attempts")
+	}
+
+	aliSvc.Backend = flakyBackend{Backend: backend, failures: failures, err: oss.ServiceError{StatusCode: http.StatusForbidden}}
+	failures.Store(1)
+	if _, err := aliSvc.backend().HeadObject(ctx, "test-bucket", "retried"); err == nil || failures.Load() != 0 {
+		t.Fatalf("Failed to stop on client error: %v", err)
+	}
+
+	failures.Store(2)
+	if _, err := aliSvc.transferBackend(TransferConfig{Retries: -1}).HeadObject(ctx, "test-bucket", "retried"); err == nil || failures.Load() != 1 {
+		t.Fatalf("Failed to disable retries by transfer config: %v", err)
+	}
+}
+
+// Backend which resets connection in the middle of first "resets" bodies of objects
+type resettingBackend struct {
+	*memoss.Backend
+	resets *atomic.Int32
+}
+
+func (b resettingBackend) GetObject(ctx context.Context, bucket, key string, start, end int64, ifMatch string) (io.ReadCloser, error) {
+	body, err := b.Backend.GetObject(ctx, bucket, key, start, end, ifMatch)
+	if err != nil || b.resets.Add(-1) < 0 {
+		return body, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(io.LimitReader(body, (end-start+1)/2), iotest.ErrReader(fmt.Errorf("read: %w", syscall.ECONNRESET))), body}, nil
+}
+
+// Backend which refuses every odd request of object and resets connections of other requests, counting requests
+type brokenBackend struct {
+	resettingBackend
+	requests *atomic.Int32
+}
+
+func (b brokenBackend) GetObject(ctx context.Context, bucket, key string, start, end int64, ifMatch string) (io.ReadCloser, error) {
+	if b.requests.Add(1)%2 == 1 {
+		return nil, fmt.Errorf("dial: %w", syscall.ECONNREFUSED)
+	}
+	return b.resettingBackend.GetObject(ctx, bucket, key, start, end, ifMatch)
+}
+
+// Writer which fails every write
+type failingWriter struct{}
+
+func (failingWriter) WriteAt([]byte, int64) (int, error) {
+	return 0, errors.New("disk is full")
+}
+
+func TestRetryBodyRead(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	aliSvc.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	resets := &atomic.Int32{}
+	aliSvc.Backend = resettingBackend{Backend: backend, resets: resets}
+
+	data := []byte(getRandomString(10*1000 + 17))
+	_ = backend.PutObject(ctx, "test-bucket", "data.bin", bytes.NewReader(data))
+
+	resets.Store(2)
+	var buf bytes.Buffer
+	err := aliSvc.DownloadWriter(ctx, "data.bin", &buf, WithPartSize(1000), WithConcurrency(1))
+	if err != nil {
+		t.Fatalf("Failed to retry reset download: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("Failed to match downloaded data")
+	}
+
+	reader, err := aliSvc.Open(ctx, "data.bin", WithPartSize(1000))
+	if err != nil {
+		t.Fatalf("Failed to open: %s", err)
+	}
+	resets.Store(2)
+	read := make([]byte, 2000)
+	if _, err := reader.ReadAt(read, 500); err != nil || !bytes.Equal(read, data[500:2500]) {
+		t.Fatalf("Failed to retry reset read: %v", err)
+	}
+
+	resets.Store(10)
+	err = aliSvc.DownloadWriter(ctx, "data.bin", io.Discard, WithPartSize(1000), WithRetries(1))
+	if !errors.Is(err, syscall.ECONNRESET) || !strings.Contains(err.Error(), "Failed to download") {
+		t.Fatalf("Failed to report reset download: %v", err)
+	}
+
+	requests := &atomic.Int32{}
+	aliSvc.Backend = brokenBackend{resettingBackend: resettingBackend{Backend: backend, resets: resets}, requests: requests}
+	resets.Store(10)
+	err = aliSvc.DownloadWriter(ctx, "data.bin", io.Discard, WithPartSize(int64(len(data))))
+	if err == nil || requests.Load() != 3 {
+		t.Fatalf("Failed to share attempts of requests and reads: %d requests, %v", requests.Load(), err)
+	}
+
+	aliSvc.Backend = resettingBackend{Backend: backend, resets: resets}
+	resets.Store(0)
+	err = aliSvc.DownloadWriterAt(ctx, "data.bin", failingWriter{}, WithPartSize(1000))
+	if err == nil || !strings.Contains(err.Error(), "Failed to write") {
+		t.Fatalf("Failed to report failed write: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, _ := newMemoryService()
+
+	_, err := aliSvc.backend().HeadObject(ctx, "test-bucket", "missing")
+	var requestErr *RequestError
+	var serviceErr oss.ServiceError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &requestErr) || requestErr.Code != "NoSuchKey" || !errors.As(err, &serviceErr) {
+		t.Fatalf("Failed to detect missing object: %v", err)
+	}
+
+	err = aliSvc.AbortUpload("missing", "missing-upload-id")
+	if !errors.Is(err, ErrUploadNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Failed to detect missing upload: %v", err)
+	}
+
+	aliSvc.Bucket = "missing-bucket"
+	err = aliSvc.UploadReader(ctx, "key", strings.NewReader("data"))
+	if !errors.Is(err, ErrBucketNotFound) || strings.HasSuffix(err.Error(), "\n") {
+		t.Fatalf("Failed to detect missing bucket: %q", err)
+	}
+
+	kinds := map[error]error{
+		oss.ServiceError{StatusCode: http.StatusForbidden, Code: "AccessDenied"}:                ErrAccessDenied,
+		oss.ServiceError{StatusCode: http.StatusServiceUnavailable, Code: "SlowDown"}:           ErrThrottled,
+		oss.ServiceError{StatusCode: http.StatusPreconditionFailed, Code: "PreconditionFailed"}: ErrPreconditionFailed,
+	}
+	for cause, kind := range kinds {
+		if err := newRequestError(cause); !errors.Is(err, kind) {
+			t.Fatalf("Failed to detect %s by %v", kind, err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	backend.MaxKeys = 3
+	keys := []string{"a", "b/1", "b/2", "c", "d/e/1", "e", "f", "g/"}
+	for _, key := range keys {
+		_ = backend.PutObject(ctx, "test-bucket", key, strings.NewReader(key))
+	}
+
+	var names []string
+	for info, err := range aliSvc.List(ctx, "") {
+		if err != nil {
+			t.Fatalf("Failed to list: %s", err)
+		}
+		names = append(names, fmt.Sprint(info.Key, info.IsPrefix))
+	}
+	expected := []string{"afalse", "b/true", "cfalse", "d/true", "efalse", "ffalse", "g/true"}
+	if !slices.Equal(names, expected) {
+		t.Fatalf("Failed to list folders: %v", names)
+	}
+
+	list, err := aliSvc.ListAll(ctx, "", WithRecursive())
+	if err != nil || len(list) != len(keys) || list[4].Key != "d/e/1" || list[4].Size != 5 {
+		t.Fatalf("Failed to list recursively: %v %v", list, err)
+	}
+
+	for range aliSvc.List(ctx, "") { // Stop iteration early
+		break
+	}
+
+	files, err := aliSvc.GetBucketFilesList("/")
+	if err != nil || len(files) != len(expected) {
+		t.Fatalf("Failed to get all pages of files: %v %v", files, err)
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	backend.MaxKeys = 2
+	for _, key := range []string{"a/1", "a/b/2", "a/b-1", "a/b/c/3", "a/d/4", "a/e/", "a/f", "skip/5", "z"} {
+		_ = backend.PutObject(ctx, "test-bucket", key, strings.NewReader(key))
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		var visited []string
+		err := aliSvc.WalkDir(ctx, "", func(key string, info ObjectInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			visited = append(visited, key)
+			if key == "skip/" {
+				return fs.SkipDir
+			}
+			return nil
+		}, WithListConcurrency(concurrency))
+		expected := []string{"", "a/", "a/1", "a/b/", "a/b/2", "a/b/c/", "a/b/c/3", "a/b-1", "a/d/", "a/d/4", "a/e/", "a/f", "skip/", "z"}
+		if err != nil || !slices.Equal(visited, expected) {
+			t.Fatalf("Failed to walk with concurrency %d: %v %v", concurrency, visited, err)
+		}
+	}
+
+	var visited []string
+	err := aliSvc.WalkDir(ctx, "/a", func(key string, info ObjectInfo, err error) error {
+		visited = append(visited, key)
+		if key == "a/b/2" {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil || !slices.Equal(visited, []string{"a/", "a/1", "a/b/", "a/b/2"}) {
+		t.Fatalf("Failed to stop walk: %v %v", visited, err)
+	}
+}
+
+// Backend which counts requests of deletion of objects
+type deleteCountingBackend struct {
+	*memoss.Backend
+	requests *atomic.Int32
+}
+
+func (b deleteCountingBackend) DeleteObjects(ctx context.Context, bucket string, keys []string) error {
+	b.requests.Add(1)
+	return b.Backend.DeleteObjects(ctx, bucket, keys)
+}
+
+func (b deleteCountingBackend) DeleteObject(ctx context.Context, bucket, key string) error {
+	b.requests.Add(1)
+	return b.Backend.DeleteObject(ctx, bucket, key)
+}
+
+func TestDeleteMatchingBatches(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	requests := &atomic.Int32{}
+	aliSvc.Backend = deleteCountingBackend{Backend: backend, requests: requests}
+	for i := 0; i < 2*maxDeleteKeys+1; i++ {
+		_ = backend.PutObject(ctx, "test-bucket", fmt.Sprintf("logs/%04d.log", i), strings.NewReader("log"))
+	}
+	for _, key := range []string{"other.log", "logs-archive/a.log", "logs2/b.log"} { // Siblings of folder aren't deleted
+		_ = backend.PutObject(ctx, "test-bucket", key, strings.NewReader("log"))
+	}
+
+	deleted, err := aliSvc.DeleteMatching(ctx, "/logs", WithListConcurrency(2), WithInclude(MustGlob("*.log")))
+	if err != nil || deleted != 2*maxDeleteKeys+1 {
+		t.Fatalf("Failed to delete objects: %d %v", deleted, err)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("Failed to delete objects by batches: %d requests", requests.Load())
+	}
+	if list, _ := aliSvc.ListAll(ctx, "", WithRecursive()); len(list) != 3 || list[0].Key != "logs-archive/a.log" {
+		t.Fatalf("Failed to keep other objects: %v", list)
+	}
+}
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	backend.MaxKeys = 3
+	_ = backend.CreateBucket(ctx, "fs-bucket")
+	for _, key := range []string{"root/a.txt", "root/dir/b.txt", "root/dir-1.txt", "root/dir/sub/c.txt", "root/empty/", "root/big", "root/x", "root/x/y.txt", "other/d.txt"} {
+		data := key
+		if key == "root/big" {
+			data = getRandomString(3000)
+		}
+		_ = backend.PutObject(ctx, "fs-bucket", key, strings.NewReader(data))
+	}
+
+	fsys := aliSvc.FS(ctx, "fs-bucket", "/root/")
+	err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir-1.txt", "dir/sub/c.txt", "empty", "big", "x") // File "x" hides folder "x/"
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key "dir-1.txt" precedes prefix "dir/" in listing, but entry "dir" precedes "dir-1.txt"
+	entries, err := fs.ReadDir(fsys, ".")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if err != nil || !slices.Equal(names, []string{"a.txt", "big", "dir", "dir-1.txt", "empty", "x"}) || entries[5].IsDir() {
+		t.Fatalf("Failed to sort entries by names: %v %v", names, err)
+	}
+
+	if data, err := fs.ReadFile(fsys, "dir/sub/c.txt"); err != nil || string(data) != "root/dir/sub/c.txt" {
+		t.Fatalf("Failed to read file: %q %v", data, err)
+	}
+	if _, err := fsys.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Failed to report missing file: %v", err)
+	}
+	if _, err := fsys.Open("other/d.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Failed to limit file system to prefix: %v", err)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	if _, err := Glob("[a"); err == nil {
+		t.Fatal("Failed to reject invalid pattern")
+	}
+	globs := map[string][]string{
+		"*.log":                {"a.log"},
+		"2024/**/report-*.csv": {"2024/report-1.csv", "2024/01/02/report-2.csv"},
+		"**/*.log":             {"a.log", "2024/01/b.log"},
+	}
+	keys := []string{"a.log", "2024/01/b.log", "2024/report-1.csv", "2024/01/02/report-2.csv", "2024/01/02/summary.csv", "big"}
+	for pattern, expected := range globs {
+		var matched []string
+		for _, key := range keys {
+			if MustGlob(pattern)(ObjectInfo{Key: key}) {
+				matched = append(matched, key)
+			}
+		}
+		if !slices.Equal(matched, expected) {
+			t.Fatalf("Failed to match pattern %s: %v", pattern, matched)
+		}
+	}
+
+	ctx := context.Background()
+	aliSvc, backend := newMemoryService()
+	backend.MaxKeys = 2
+	for _, key := range keys {
+		data := key
+		if key == "big" {
+			data = getRandomString(1000)
+		}
+		_ = backend.PutObject(ctx, "test-bucket", key, strings.NewReader(data))
+	}
+
+	list, err := aliSvc.ListAll(ctx, "", WithRecursive(), WithInclude(MustGlob("**/*.csv"), MinSize(100)), WithExclude(MustGlob("**/summary.*")))
+	if err != nil || len(list) != 3 || list[2].Key != "big" {
+		t.Fatalf("Failed to filter listing: %v %v", list, err)
+	}
+	list, err = aliSvc.ListAll(ctx, "", WithInclude(All(InStorageClass("standard"), NewerThan(time.Hour), Not(OlderThan(time.Hour)))))
+	if err != nil || len(list) != 3 || !list[0].IsPrefix {
+		t.Fatalf("Failed to filter listing with folders: %v %v", list, err)
+	}
+
+	var visited []string
+	err = aliSvc.WalkDir(ctx, "2024", func(key string, info ObjectInfo, err error) error {
+		if !info.IsPrefix {
+			visited = append(visited, key)
+		}
+		return err
+	}, WithInclude(MustGlob("**/report-*.csv")))
+	if err != nil || !slices.Equal(visited, []string{"2024/01/02/report-2.csv", "2024/report-1.csv"}) {
+		t.Fatalf("Failed to filter walk: %v %v", visited, err)
+	}
+
+	files, err := aliSvc.GetBucketFilesList("2024/01", WithInclude(MustGlob("*.log")))
+	if err != nil || len(files) != 2 || files[0].Key != "2024/01/b.log" || files[1].Key != "2024/01/02/" {
+		t.Fatalf("Failed to match keys relative to folder: %v %v", files, err)
+	}
+
+	deleted, err := aliSvc.DeleteMatching(ctx, "2024/", WithExclude(Any(MustGlob("**/*.log"), MaxSize(0))), WithListConcurrency(3))
+	if err != nil || deleted != 3 {
+		t.Fatalf("Failed to delete matching objects: %d %v", deleted, err)
+	}
+	if list, _ := aliSvc.ListAll(ctx, "", WithRecursive()); len(list) != 3 || list[0].Key != "2024/01/b.log" {
+		t.Fatalf("Failed to keep excluded objects: %v", list)
+	}
+}
@@ -0,0 +1,221 @@
+package alioss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// Backend is a set of storage operations AliOss is built on.
+// By default AliOss sends them to Aliyun OSS through Svc,
+// set AliOss.Backend to substitute another implementation, e.g. a fake in tests.
+// Errors of missing buckets, objects or uploads should be reported as oss.ServiceError.
+// Operations should be aborted as soon as context is done.
+type Backend interface {
+	// List names of available buckets
+	ListBuckets(ctx context.Context) ([]string, error)
+	// Create bucket
+	CreateBucket(ctx context.Context, bucket string) error
+	// List objects with "prefix" starting after "marker", "delimiter" can be ""
+	ListObjects(ctx context.Context, bucket, prefix, delimiter, marker string) (oss.ListObjectsResult, error)
+	// Get object metadata as HTTP headers
+	HeadObject(ctx context.Context, bucket, key string) (http.Header, error)
+	// Get object bytes from "start" to "end" inclusive, negative "end" means up to the end of object.
+	// Non-empty "ifMatch" is ETag, which object must have, otherwise request fails with status 412.
+	GetObject(ctx context.Context, bucket, key string, start, end int64, ifMatch string) (io.ReadCloser, error)
+	// Put object
+	PutObject(ctx context.Context, bucket, key string, reader io.Reader) error
+	// Delete object
+	DeleteObject(ctx context.Context, bucket, key string) error
+	// Delete up to 1000 objects by one request, missing objects aren't errors
+	DeleteObjects(ctx context.Context, bucket string, keys []string) error
+	// Initiate multipart upload and return its upload id
+	InitiateMultipartUpload(ctx context.Context, bucket, key string) (string, error)
+	// Upload part of "size" bytes
+	UploadPart(ctx context.Context, bucket, key, uploadId string, partNumber int, reader io.Reader, size int64) (oss.UploadPart, error)
+	// List unfinished multipart uploads with "prefix" starting after "keyMarker" and "uploadIdMarker"
+	ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIdMarker string) (oss.ListMultipartUploadResult, error)
+	// List uploaded parts starting after "partNumberMarker"
+	ListUploadedParts(ctx context.Context, bucket, key, uploadId string, partNumberMarker int) (oss.ListUploadedPartsResult, error)
+	// Complete multipart upload from "parts"
+	CompleteMultipartUpload(ctx context.Context, bucket, key, uploadId string, parts []oss.UploadPart) error
+	// Abort multipart upload
+	AbortMultipartUpload(ctx context.Context, bucket, key, uploadId string) error
+}
+
+const (
+	maxListKeys   int = 1000 // Number of keys in page of listing, OSS limit, default is only 100
+	maxDeleteKeys int = 1000 // Number of keys deleted by one request, OSS limit
+)
+
+// Backend implementation over Aliyun OSS SDK client
+type ossBackend struct {
+	client *oss.Client
+}
+
+func (b ossBackend) ListBuckets(ctx context.Context) (list []string, err error) {
+	result, err := b.client.ListBuckets(oss.WithContext(ctx))
+	if err != nil {
+		return
+	}
+
+	for _, bucket := range result.Buckets {
+		list = append(list, bucket.Name)
+	}
+	return
+}
+
+func (b ossBackend) CreateBucket(ctx context.Context, bucket string) error {
+	return b.client.CreateBucket(bucket, oss.WithContext(ctx))
+}
+
+func (b ossBackend) ListObjects(ctx context.Context, bucket, prefix, delimiter, marker string) (result oss.ListObjectsResult, err error) {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	return bkt.ListObjects(oss.Prefix(prefix), oss.Delimiter(delimiter), oss.Marker(marker), oss.MaxKeys(maxListKeys), oss.WithContext(ctx))
+}
+
+func (b ossBackend) HeadObject(ctx context.Context, bucket, key string) (headers http.Header, err error) {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	return bkt.GetObjectDetailedMeta(key, oss.WithContext(ctx))
+}
+
+func (b ossBackend) GetObject(ctx context.Context, bucket, key string, start, end int64, ifMatch string) (body io.ReadCloser, err error) {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	options := []oss.Option{oss.WithContext(ctx)}
+	if ifMatch != "" {
+		options = append(options, oss.IfMatch(ifMatch))
+	}
+	if end < 0 && start > 0 {
+		options = append(options, oss.NormalizedRange(fmt.Sprintf("%d-", start)))
+	}
+	if end >= 0 {
+		options = append(options, oss.Range(start, end))
+	}
+	return bkt.GetObject(key, options...)
+}
+
+func (b ossBackend) PutObject(ctx context.Context, bucket, key string, reader io.Reader) error {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return bkt.PutObject(key, reader, oss.WithContext(ctx))
+}
+
+func (b ossBackend) DeleteObject(ctx context.Context, bucket, key string) error {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return bkt.DeleteObject(key, oss.WithContext(ctx))
+}
+
+func (b ossBackend) DeleteObjects(ctx context.Context, bucket string, keys []string) error {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	_, err = bkt.DeleteObjects(keys, oss.DeleteObjectsQuiet(true), oss.WithContext(ctx))
+	return err
+}
+
+func (b ossBackend) InitiateMultipartUpload(ctx context.Context, bucket, key string) (uploadId string, err error) {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	result, err := bkt.InitiateMultipartUpload(key, oss.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	return result.UploadID, nil
+}
+
+func (b ossBackend) UploadPart(ctx context.Context, bucket, key, uploadId string, partNumber int, reader io.Reader, size int64) (part oss.UploadPart, err error) {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	return bkt.UploadPart(imur(bucket, key, uploadId), reader, size, partNumber, oss.WithContext(ctx))
+}
+
+func (b ossBackend) ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIdMarker string) (result oss.ListMultipartUploadResult, err error) {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	return bkt.ListMultipartUploads(oss.Prefix(prefix), oss.KeyMarker(keyMarker), oss.UploadIDMarker(uploadIdMarker), oss.WithContext(ctx))
+}
+
+func (b ossBackend) ListUploadedParts(ctx context.Context, bucket, key, uploadId string, partNumberMarker int) (result oss.ListUploadedPartsResult, err error) {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return
+	}
+
+	options := []oss.Option{oss.WithContext(ctx)}
+	if partNumberMarker > 0 {
+		options = append(options, oss.PartNumberMarker(partNumberMarker))
+	}
+	return bkt.ListUploadedParts(imur(bucket, key, uploadId), options...)
+}
+
+func (b ossBackend) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadId string, parts []oss.UploadPart) error {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	_, err = bkt.CompleteMultipartUpload(imur(bucket, key, uploadId), parts, oss.WithContext(ctx))
+	return err
+}
+
+func (b ossBackend) AbortMultipartUpload(ctx context.Context, bucket, key, uploadId string) error {
+	bkt, err := b.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return bkt.AbortMultipartUpload(imur(bucket, key, uploadId), oss.WithContext(ctx))
+}
+
+// Identity of multipart upload in terms of SDK
+func imur(bucket, key, uploadId string) oss.InitiateMultipartUploadResult {
+	return oss.InitiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadId,
+	}
+}
+
+// Check that request has failed because of unmatched If-Match
+func isPreconditionFailed(err error) bool {
+	return errors.Is(err, ErrPreconditionFailed)
+}
+
+// Check if error reports missing bucket, object or upload
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
@@ -0,0 +1,322 @@
+package alioss
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// Minimal interval between saves of checkpoint of running upload
+const checkpointSaveInterval = time.Second
+
+// State of multipart upload of local file saved between upload calls
+type UploadCheckpoint struct {
+	Bucket   string
+	Key      string
+	UploadId string
+	Size     int64     // Size of local file
+	ModTime  time.Time // Modification time of local file
+	PartSize int64
+	Parts    map[int]string // ETags of uploaded parts by part numbers
+}
+
+// Storage of upload checkpoints by ids
+type CheckpointStore interface {
+	// Get checkpoint, nil if there is no checkpoint with such id
+	Load(id string) (*UploadCheckpoint, error)
+	// Save checkpoint
+	Save(id string, checkpoint *UploadCheckpoint) error
+	// Delete checkpoint, no error if there is no checkpoint with such id
+	Delete(id string) error
+}
+
+// Check that checkpoint is saved for the same local file and remote key
+func (checkpoint *UploadCheckpoint) matches(bucket, key string, stat os.FileInfo) bool {
+	return checkpoint.Bucket == bucket &&
+		checkpoint.Key == key &&
+		checkpoint.Size == stat.Size() &&
+		checkpoint.ModTime.Equal(stat.ModTime())
+}
+
+// Recorder of uploaded parts into checkpoint of running upload.
+// Parts are recorded in memory, checkpoint is saved in background at most once per interval and on stop,
+// so workers of upload don't wait for writes of store.
+type checkpointSaver struct {
+	store      CheckpointStore
+	id         string
+	log        *log.Logger
+	interval   time.Duration
+	changed    chan struct{} // Signal of recorded part
+	stop, done chan struct{}
+
+	mu         sync.Mutex
+	checkpoint *UploadCheckpoint
+	dirty      bool // Checkpoint has parts, which aren't saved
+}
+
+// Start saving of "checkpoint" with "id" to "store", caller must Stop saver
+func newCheckpointSaver(store CheckpointStore, id string, checkpoint *UploadCheckpoint, logger *log.Logger, interval time.Duration) *checkpointSaver {
+	saver := &checkpointSaver{
+		store:      store,
+		id:         id,
+		log:        logger,
+		interval:   interval,
+		changed:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		checkpoint: checkpoint,
+	}
+	go saver.run()
+	return saver
+}
+
+// Record uploaded part
+func (saver *checkpointSaver) addPart(part oss.UploadPart) {
+	saver.mu.Lock()
+	saver.checkpoint.Parts[part.PartNumber] = part.ETag
+	saver.dirty = true
+	saver.mu.Unlock()
+
+	select {
+	case saver.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Save recorded parts and stop saving
+func (saver *checkpointSaver) Stop() {
+	close(saver.stop)
+	<-saver.done
+}
+
+func (saver *checkpointSaver) run() {
+	defer close(saver.done)
+	for {
+		select {
+		case <-saver.changed:
+		case <-saver.stop:
+			saver.save()
+			return
+		}
+		saver.save()
+
+		timer := time.NewTimer(saver.interval)
+		select {
+		case <-timer.C:
+		case <-saver.stop:
+			timer.Stop()
+			saver.save()
+			return
+		}
+	}
+}
+
+// Save copy of checkpoint if it has unsaved parts
+func (saver *checkpointSaver) save() {
+	saver.mu.Lock()
+	if !saver.dirty {
+		saver.mu.Unlock()
+		return
+	}
+	checkpoint := *saver.checkpoint
+	checkpoint.Parts = maps.Clone(saver.checkpoint.Parts)
+	saver.dirty = false
+	saver.mu.Unlock()
+
+	err := saver.store.Save(saver.id, &checkpoint)
+	if err != nil {
+		saver.log.Printf("Failed to save checkpoint %s: %s\n", saver.id, err)
+	}
+}
+
+// Store of checkpoints as files "id.cp" in directory
+type DirCheckpointStore struct {
+	Dir string
+}
+
+// Get store of checkpoints in directory "dir", which is created on first save
+func NewDirCheckpointStore(dir string) DirCheckpointStore {
+	return DirCheckpointStore{Dir: dir}
+}
+
+func (store DirCheckpointStore) Load(id string) (*UploadCheckpoint, error) {
+	data, err := os.ReadFile(store.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &UploadCheckpoint{}
+	err = json.Unmarshal(data, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (store DirCheckpointStore) Save(id string, checkpoint *UploadCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(store.Dir, 0770)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path(id), data)
+}
+
+func (store DirCheckpointStore) Delete(id string) error {
+	err := os.Remove(store.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (store DirCheckpointStore) path(id string) string {
+	return filepath.Join(store.Dir, id+".cp")
+}
+
+// Store of all checkpoints in single file
+type FileCheckpointStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Get store of checkpoints in file "path", which is created on first save
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{Path: path}
+}
+
+func (store *FileCheckpointStore) Load(id string) (*UploadCheckpoint, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	checkpoints, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+	return checkpoints[id], nil
+}
+
+func (store *FileCheckpointStore) Save(id string, checkpoint *UploadCheckpoint) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	checkpoints, err := store.read()
+	if err != nil {
+		return err
+	}
+	checkpoints[id] = checkpoint
+	return store.write(checkpoints)
+}
+
+func (store *FileCheckpointStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	checkpoints, err := store.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := checkpoints[id]; !ok {
+		return nil
+	}
+	delete(checkpoints, id)
+	return store.write(checkpoints)
+}
+
+func (store *FileCheckpointStore) read() (map[string]*UploadCheckpoint, error) {
+	checkpoints := make(map[string]*UploadCheckpoint)
+	data, err := os.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &checkpoints)
+	return checkpoints, err
+}
+
+func (store *FileCheckpointStore) write(checkpoints map[string]*UploadCheckpoint) error {
+	data, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.Path, data)
+}
+
+// Store of checkpoints in memory of process
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]UploadCheckpoint
+}
+
+// Get empty store of checkpoints in memory
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]UploadCheckpoint)}
+}
+
+func (store *MemoryCheckpointStore) Load(id string) (*UploadCheckpoint, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	checkpoint, ok := store.checkpoints[id]
+	if !ok {
+		return nil, nil
+	}
+	checkpoint.Parts = maps.Clone(checkpoint.Parts)
+	return &checkpoint, nil
+}
+
+func (store *MemoryCheckpointStore) Save(id string, checkpoint *UploadCheckpoint) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	saved := *checkpoint
+	saved.Parts = maps.Clone(checkpoint.Parts)
+	store.checkpoints[id] = saved
+	return nil
+}
+
+func (store *MemoryCheckpointStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.checkpoints, id)
+	return nil
+}
+
+// Write file atomically, so crash leaves either previous or new version
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
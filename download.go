@@ -1,11 +1,13 @@
 package alioss
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -17,157 +19,410 @@ const (
 
 )
 
-// Download remote "fileName" to local file in "destinationPath"
-func (alioss AliOss) Download(fileName, destinationPath string) error {
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
+// Download remote "fileName" to local file in "destinationPath" by parallel parts.
+// Parts are written into temporary file in the same folder, which replaces destination file after download.
+func (alioss AliOss) Download(fileName, destinationPath string, opts ...TransferOption) error {
+	return alioss.DownloadContext(context.Background(), fileName, destinationPath, opts...)
+}
+
+// Same as Download with context
+func (alioss AliOss) DownloadContext(ctx context.Context, fileName, destinationPath string, opts ...TransferOption) error {
+	file, err := os.CreateTemp(filepath.Dir(destinationPath), filepath.Base(destinationPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %s: %w", destinationPath, err)
+	}
+
+	err = alioss.DownloadWriterAt(ctx, fileName, file, opts...)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if err == nil {
+		err = file.Close()
+	} else {
+		alioss.IoClose(file)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), destinationPath)
+	}
+	if err != nil {
+		if errRemove := os.Remove(file.Name()); errRemove != nil {
+			alioss.Log.Printf("Failed to remove temporary file %s: %s\n", file.Name(), errRemove)
+		}
+		if err == ctx.Err() {
+			return err
+		}
+		return fmt.Errorf("Failed to download file %s to %s: %w", fileName, destinationPath, err)
+	}
+
+	alioss.Log.Printf("Successfully downloaded %s to %s\n", fileName, destinationPath)
+	return nil
+}
+
+// Download remote "fileName" to "w" by parallel parts written at their offsets
+func (alioss AliOss) DownloadWriterAt(ctx context.Context, fileName string, w io.WriterAt, opts ...TransferOption) error {
+	cfg := alioss.downloadConfig(opts)
+	err := cfg.validateDownload()
 	if err != nil {
-		return fmt.Errorf("Failed to download file %s: %s\n", fileName, err)
+		return fmt.Errorf("Failed to download %s: %w", fileName, err)
 	}
 
 	fileName = strings.TrimPrefix(fileName, "/")
-	err = bucket.GetObjectToFile(fileName, destinationPath)
+	info, err := alioss.StatContext(ctx, fileName)
+	if err != nil {
+		return fmt.Errorf("Failed to download %s: %w", fileName, err)
+	}
+
+	return alioss.downloadParts(ctx, cfg, w, splitParts(fileName, info.ETag, 0, info.Size, cfg.PartSize), nil)
+}
+
+// Download remote "fileName" to sequential "w" by parallel parts, which are reordered in memory
+func (alioss AliOss) DownloadWriter(ctx context.Context, fileName string, w io.Writer, opts ...TransferOption) error {
+	cfg := alioss.downloadConfig(opts)
+	return alioss.DownloadWriterAt(ctx, fileName, newOrderedWriter(w, int64(cfg.Concurrency)*cfg.PartSize), opts...)
+}
+
+// Split bytes from "offset" to "size" of remote "key" with "etag" into parts
+func splitParts(key, etag string, offset, size, partSize int64) (parts []filePart) {
+	for partOffset := offset; partOffset < size; partOffset += partSize {
+		length := min(partSize, size-partOffset)
+		parts = append(parts, filePart{
+			Key:        key,
+			Range:      fmt.Sprintf("bytes=%d-%d", partOffset, partOffset+length-1),
+			Etag:       etag,
+			Offset:     partOffset,
+			Length:     length,
+			PartNumber: int(partOffset/partSize) + 1,
+		})
+	}
+	return
+}
+
+// Download parts to "w" in parallel, "done" is called after write of each part if it's set.
+// First failed part stops download, error lists all failed parts.
+func (alioss AliOss) downloadParts(ctx context.Context, cfg TransferConfig, w io.WriterAt, parts []filePart, done func(filePart) error) error {
+	group, partCtx := newPartGroup(ctx)
+
+	if ordered, ok := w.(*orderedWriter); ok { // Wake up parts waiting for their turn
+		stop := context.AfterFunc(partCtx, func() {
+			ordered.fail(partCtx.Err())
+		})
+		defer stop()
+	}
+
+	partQueue := make(chan filePart, cfg.Concurrency)
+	for i := 0; i < cfg.Concurrency; i++ {
+		group.Go(func() error {
+			for part := range partQueue {
+				err := alioss.downloadPart(partCtx, cfg, part, w)
+				if err == nil && done != nil {
+					err = done(part)
+				}
+				if err != nil {
+					group.failPart(part.PartNumber, err)
+				}
+			}
+			return nil
+		})
+	}
+
+	group.Go(func() error {
+		defer close(partQueue)
+		for _, part := range parts {
+			select {
+			case partQueue <- part:
+			case <-partCtx.Done():
+				alioss.Log.Printf("Download of %s interrupted at range %s: %s\n", part.Key, part.Range, partCtx.Err())
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return group.Wait()
+}
+
+// Download part and write it at its offset, part is requested only if remote file still has its ETag
+func (alioss AliOss) downloadPart(ctx context.Context, cfg TransferConfig, part filePart, w io.WriterAt) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	alioss.Log.Printf("Start to download part for key %s: Range: %s\n", part.Key, part.Range)
+	n, err := alioss.getRange(ctx, cfg.retryPolicy(alioss.Retry), part.Key, part.Etag, part.Offset, part.Length, io.NewOffsetWriter(w, part.Offset))
+	var writeErr *writeError
+	if errors.As(err, &writeErr) {
+		return fmt.Errorf("Failed to write file %s range %s: %w", part.Key, part.Range, writeErr.err)
+	}
+	if isPreconditionFailed(err) {
+		err = ErrObjectChanged
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to download file %s to %s: %s\n", fileName, destinationPath, err)
+		return fmt.Errorf("Failed to download file %s range %s: %w", part.Key, part.Range, err)
 	}
 
+	alioss.Log.Printf("Finish write %d bytes part range %s for key %s\n", n, part.Range, part.Key)
 	return nil
 }
 
-// Resume download of remote "fileName" to existed local file in "destinationPath"
-func (alioss AliOss) ResumeDownload(fileName, destinationPath string) error {
-	remoteFileInfo, err := alioss.GetFileInfo(fileName)
+// Copy "length" bytes of "key" at "offset" to "w" by "policy".
+// Failed requests and failed reading of body share attempts of policy, every attempt requests remaining bytes.
+// Errors of "w" are returned as *writeError.
+func (alioss AliOss) getRange(ctx context.Context, policy RetryPolicy, key, etag string, offset, length int64, w io.Writer) (n int64, err error) {
+	policy = policy.withDefaults()
+	attemptPolicy := policy // Single request with timeout of attempt, retries are done here
+	attemptPolicy.MaxAttempts = 1
+	backend := retryingBackend{backend: alioss.baseBackend(), policy: attemptPolicy, log: alioss.Log}
+	for attempt := 1; ; attempt++ {
+		copied, err := alioss.getRangeAttempt(ctx, backend, key, etag, offset+n, offset+length-1, w)
+		n += copied
+		var writeErr *writeError
+		if err == nil || errors.As(err, &writeErr) || ctx.Err() != nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return n, err
+		}
+
+		delay := policy.backoff(attempt)
+		alioss.Log.Printf("Try %d of get of key %s at offset %d has failed: %s. Repeat in %s...\n", attempt, key, offset+n, err, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return n, ctx.Err()
+		}
+	}
+}
+
+// Copy bytes of "key" from "start" to "end" inclusive to "w" by single request, returns number of copied bytes
+func (alioss AliOss) getRangeAttempt(ctx context.Context, backend Backend, key, etag string, start, end int64, w io.Writer) (int64, error) {
+	body, err := backend.GetObject(ctx, alioss.Bucket, key, start, end, etag)
 	if err != nil {
-		alioss.Log.Printf("Failed to get file %s: %s\n", fileName, err)
-		return err
+		return 0, err
 	}
+	defer alioss.IoClose(body)
 
-	file, err := os.OpenFile(destinationPath, os.O_WRONLY, 666)
+	tracked := &trackingWriter{w: w}
+	copied, err := io.Copy(tracked, io.LimitReader(body, end-start+1))
+	if tracked.err != nil {
+		return copied, &writeError{err: tracked.err}
+	}
+	if err == nil && copied < end-start+1 { // Object is shorter than requested or connection is closed
+		err = io.ErrUnexpectedEOF
+	}
+	return copied, err
+}
+
+// Error of writing of downloaded content
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *writeError) Unwrap() error {
+	return e.err
+}
+
+// Writer which keeps error of underlying writer
+type trackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackingWriter) Write(p []byte) (n int, err error) {
+	n, err = t.w.Write(p)
+	if err != nil {
+		t.err = err
+	}
+	return
+}
+
+// Resume download of remote "fileName" to existed local file in "destinationPath".
+// Downloaded ranges are recorded in journal "destinationPath.alioss-resume", so resume skips exactly them
+// and starts from scratch if remote file has changed. Without journal size of local file is taken as downloaded.
+func (alioss AliOss) ResumeDownload(fileName, destinationPath string, opts ...TransferOption) error {
+	return alioss.ResumeDownloadContext(context.Background(), fileName, destinationPath, opts...)
+}
+
+// Same as ResumeDownload with context
+func (alioss AliOss) ResumeDownloadContext(ctx context.Context, fileName, destinationPath string, opts ...TransferOption) error {
+	cfg := alioss.downloadConfig(opts)
+	err := cfg.validateDownload()
+	if err != nil {
+		return fmt.Errorf("Failed to resume download of %s: %w", fileName, err)
+	}
+
+	fileName = strings.TrimPrefix(fileName, "/")
+	remoteFileInfo, err := alioss.StatContext(ctx, fileName)
+	if err != nil {
+		return fmt.Errorf("Failed to get file %s: %w", fileName, err)
+	}
+	contentLength := remoteFileInfo.Size
+
+	file, err := os.OpenFile(destinationPath, os.O_WRONLY, 0666)
 	if err != nil {
-		return fmt.Errorf("Failed to create destination file %s: %s\n", destinationPath, err)
+		return fmt.Errorf("Failed to create destination file %s: %w", destinationPath, err)
 	}
 	defer alioss.IoClose(file)
 
 	stat, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("Failed to stat destination file %s: %s\n", destinationPath, err)
+		return fmt.Errorf("Failed to stat destination file %s: %w", destinationPath, err)
 	}
 
-	tmpContentLength, err := strconv.Atoi(remoteFileInfo.Get("Content-Length"))
-	if err != nil {
-		return fmt.Errorf("Failed to get header Content-Length of remote file %s: %s\n", fileName, err)
+	journal := &downloadJournal{
+		path:         destinationPath + journalSuffix,
+		ETag:         remoteFileInfo.ETag,
+		LastModified: remoteFileInfo.LastModified.UTC().Format(http.TimeFormat),
+		Size:         contentLength,
+		PartSize:     cfg.PartSize,
+	}
+	saved, err := loadDownloadJournal(journal.path)
+	switch {
+	case err == nil && saved.matches(journal):
+		alioss.Log.Printf("Continue download of %s from journal %s\n", fileName, journal.path)
+		journal.PartSize = saved.PartSize
+		journal.Completed = saved.Completed
+	case err == nil:
+		alioss.Log.Printf("Remote file %s has changed since journal %s. Download from scratch.\n", fileName, journal.path)
+		err = file.Truncate(0)
+		if err != nil {
+			return fmt.Errorf("Failed to truncate destination file %s: %w", destinationPath, err)
+		}
+	case os.IsNotExist(err):
+		if contentLength < stat.Size() {
+			return fmt.Errorf("Failed to compare size of remote %s and destination file %s: %d <= %d", fileName, destinationPath, contentLength, stat.Size())
+		}
+		journal.add(0, stat.Size())
+	default:
+		return fmt.Errorf("Failed to read journal %s: %w", journal.path, err)
 	}
 
-	var contentLength int64
-	contentLength = int64(tmpContentLength)
-	if contentLength < stat.Size() {
-		return fmt.Errorf("Failed to compare size of remote %s and destination file %s: %d <= %d\n", fileName, destinationPath, contentLength, stat.Size())
+	parts := journal.missingParts(fileName)
+	if len(parts) == 0 {
+		alioss.Log.Printf("Remote %s is already downloaded to %s. Nothing to do.\n", fileName, destinationPath)
+		return alioss.finishDownload(file, journal)
 	}
 
-	if contentLength == stat.Size() {
-		alioss.Log.Printf("Size of remote %s and destination %s file match: %d == %d. Nothing to do.\n", fileName, destinationPath, contentLength, stat.Size())
-		return nil
+	err = journal.save()
+	if err != nil {
+		return fmt.Errorf("Failed to save journal %s: %w", journal.path, err)
 	}
 
-	d := downloader{
-		AliOss:     alioss,
-		File:       file,
-		FileOffset: stat.Size(),
-	}
-
-	taskPartChan := make(chan filePart, DefaultDownloadConcurrency)
-	var wg sync.WaitGroup
-	for i := 0; i < DefaultDownloadConcurrency; i++ {
-		wg.Add(1)
-		go d.asyncDownloadPart(taskPartChan, &wg)
-	}
-
-	partOffset := stat.Size()
-	leftBytes := contentLength - stat.Size()
-	go func() {
-		for {
-			alioss.Log.Printf("Resume download: Left bytes %d\n", leftBytes)
-			if leftBytes <= DefaultDownloadPartSize {
-				partRange := fmt.Sprintf("bytes=%d-%d", partOffset, partOffset+leftBytes-1)
-				alioss.Log.Printf("Resume download: File range %s\n", partRange)
-				taskPartChan <- filePart{
-					Key:    fileName,
-					Range:  partRange,
-					Offset: partOffset,
-					Length: leftBytes,
-					Body:   make([]byte, leftBytes),
-				}
-				close(taskPartChan)
-				alioss.Log.Println("Resume download: All parts send to download. Close channel.")
-				return
-			}
-			fileRange := fmt.Sprintf("bytes=%d-%d", partOffset, partOffset+DefaultDownloadPartSize-1)
-			alioss.Log.Printf("Resume download: Part range %s\n", fileRange)
-			alioss.Log.Printf("Resume download: Part offset %d\n", partOffset)
-
-			taskPartChan <- filePart{
-				Key:    fileName,
-				Range:  fileRange,
-				Offset: partOffset,
-				Length: DefaultDownloadPartSize,
-			}
-			partOffset = partOffset + DefaultDownloadPartSize
-			leftBytes = leftBytes - DefaultDownloadPartSize
+	var mu sync.Mutex
+	err = alioss.downloadParts(ctx, cfg, file, parts, func(part filePart) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		err := file.Sync() // Part must be on disk before it's recorded
+		if err != nil {
+			return fmt.Errorf("Failed to sync destination file %s: %w", destinationPath, err)
 		}
-	}()
+		journal.add(part.Offset, part.Length)
+		return journal.save()
+	})
+	if err == ctx.Err() && err != nil {
+		alioss.Log.Printf("Download of remote %s to %s interrupted: %s\n", fileName, destinationPath, err)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to download remote %s to %s: %w", fileName, destinationPath, err)
+	}
 
-	wg.Wait()
-	if d.Err != nil {
-		return fmt.Errorf("Failed to download remote %s to %s: %s", fileName, destinationPath, d.Err)
+	return alioss.finishDownload(file, journal)
+}
+
+// Cut destination file to size of remote file and remove journal
+func (alioss AliOss) finishDownload(file *os.File, journal *downloadJournal) error {
+	err := file.Truncate(journal.Size)
+	if err != nil {
+		return fmt.Errorf("Failed to truncate destination file %s: %w", file.Name(), err)
 	}
 
+	err = os.Remove(journal.path)
+	if err != nil && !os.IsNotExist(err) {
+		alioss.Log.Printf("Failed to remove journal %s: %s\n", journal.path, err)
+	}
 	return nil
 }
 
-func (alioss *downloader) asyncDownloadPart(taskPartChan <-chan filePart, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		if part, ok := <-taskPartChan; ok {
-			if alioss.Err != nil {
-				alioss.Log.Printf("Failed to start download %s: %s\n", part.Range, alioss.Err)
-				return
-			}
-			alioss.Log.Printf("Start to download part for key %s: Range: %s, Offset: %d, Length: %d\n", part.Key, part.Range, part.Offset, part.Length)
-
-			body, err := alioss.GetFilePart(part.Key, part.Offset, part.Offset+part.Length-1)
-			alioss.Log.Printf("Request sent for %s range %s\n", part.Key, part.Range)
-			if err != nil {
-				alioss.Err = errors.New(fmt.Sprintf("Failed to download file %s range %s: %s\n", part.Key, part.Range, err))
-				alioss.Log.Printf(alioss.Err.Error())
-				return
-			}
-			alioss.Log.Printf("Recieved response for %s range %s\n", part.Key, part.Range)
-			alioss.Log.Printf("File offset: %d\n", alioss.FileOffset)
-			alioss.Log.Printf("Part offset: %d\n", part.Offset)
-
-			for {
-				if alioss.Err != nil {
-					alioss.Log.Printf("Failed to write download %s: %s\n", part.Range, alioss.Err)
-					return
-				}
-				if alioss.FileOffset == part.Offset {
-					n, err := io.Copy(alioss.File, &body)
-					if err != nil {
-						alioss.Err = errors.New(fmt.Sprintf("Failed to write file %s range %s: %s\n", part.Key, part.Range, err))
-						alioss.Log.Printf(alioss.Err.Error())
-						return
-					}
-
-					alioss.Log.Printf("Finish write %d bytes part range %s for key %s \n", n, part.Range, part.Key)
-
-					alioss.FileOffset = part.Offset + part.Length
-					alioss.Log.Printf("New file offset: %d\n", alioss.FileOffset)
-					break
-				}
-				time.Sleep(10 * time.Millisecond)
-			}
-		} else {
-			alioss.Log.Println("Download channel closed. Return.")
-			return
+// Writer of parts to sequential sink in order of their offsets.
+// Parts ahead of sink offset are kept in memory up to limit, then writers of such parts wait for their turn.
+type orderedWriter struct {
+	w       io.Writer
+	limit   int64
+	mu      sync.Mutex
+	cond    *sync.Cond
+	offset  int64            // Offset of next write to sink
+	pending map[int64][]byte // Parts ahead of offset by their offsets
+	size    int64            // Size of pending parts
+	err     error            // First failure, stops all writes
+}
+
+func newOrderedWriter(w io.Writer, limit int64) *orderedWriter {
+	o := &orderedWriter{
+		w:       w,
+		limit:   limit,
+		pending: make(map[int64][]byte),
+	}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// Write "p" at "off" as soon as all previous bytes are written
+func (o *orderedWriter) WriteAt(p []byte, off int64) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for o.err == nil && off != o.offset && o.size+int64(len(p)) > o.limit && o.size > 0 {
+		o.cond.Wait()
+	}
+	if o.err != nil {
+		return 0, o.err
+	}
+	if off < o.offset {
+		return 0, fmt.Errorf("Failed to write at offset %d: already written up to %d", off, o.offset)
+	}
+
+	if off != o.offset {
+		o.pending[off] = append([]byte(nil), p...)
+		o.size += int64(len(p))
+		return len(p), nil
+	}
+
+	err := o.write(p)
+	for err == nil {
+		next, ok := o.pending[o.offset]
+		if !ok {
+			break
 		}
+		delete(o.pending, o.offset)
+		o.size -= int64(len(next))
+		err = o.write(next)
+	}
+	o.cond.Broadcast()
+	if err != nil {
+		o.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Write to sink, caller holds lock
+func (o *orderedWriter) write(p []byte) error {
+	n, err := o.w.Write(p)
+	o.offset += int64(n)
+	return err
+}
+
+// Stop all waiting and following writes with "err"
+func (o *orderedWriter) fail(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.err == nil {
+		o.err = err
 	}
+	o.cond.Broadcast()
 }
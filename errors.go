@@ -0,0 +1,85 @@
+package alioss
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// Kinds of failed requests, use errors.Is to check them and errors.As with *RequestError to get details
+var (
+	ErrNotFound           = errors.New("Not found")            // Missing object, bucket or upload
+	ErrBucketNotFound     = errors.New("Bucket not found")     // Also matches ErrNotFound
+	ErrUploadNotFound     = errors.New("Upload not found")     // Also matches ErrNotFound
+	ErrAccessDenied       = errors.New("Access denied")        // Request is forbidden for credentials
+	ErrPreconditionFailed = errors.New("Precondition failed")  // Conditional request hasn't matched, e.g. If-Match
+	ErrThrottled          = errors.New("Request is throttled") // Too many requests, retry later
+)
+
+// Remote object has been changed by someone else during transfer
+var ErrObjectChanged = errors.New("Object has changed during transfer")
+
+// Failed request to OSS with details of response
+type RequestError struct {
+	StatusCode int    // HTTP status code of response
+	Code       string // OSS error code, e.g. NoSuchKey
+	RequestID  string // OSS request id for support
+	Err        error  // Original error, e.g. oss.ServiceError
+
+	kinds []error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+// Get kinds of error and original error for errors.Is and errors.As
+func (e *RequestError) Unwrap() []error {
+	return append(e.kinds[:len(e.kinds):len(e.kinds)], e.Err)
+}
+
+// Get error of request with kinds detected by response of OSS, nil for nil.
+// Errors without response are returned as is.
+func newRequestError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return err
+	}
+
+	e := &RequestError{Err: err}
+	var serviceErr oss.ServiceError
+	var statusErr oss.UnexpectedStatusCodeError
+	switch {
+	case errors.As(err, &serviceErr):
+		e.StatusCode, e.Code, e.RequestID = serviceErr.StatusCode, serviceErr.Code, serviceErr.RequestID
+	case errors.As(err, &statusErr):
+		e.StatusCode = statusErr.Got()
+	default:
+		return err
+	}
+
+	switch e.Code {
+	case "NoSuchBucket":
+		e.kinds = append(e.kinds, ErrBucketNotFound)
+	case "NoSuchUpload":
+		e.kinds = append(e.kinds, ErrUploadNotFound)
+	case "Throttling", "SlowDown":
+		e.kinds = append(e.kinds, ErrThrottled)
+		return e
+	}
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		e.kinds = append(e.kinds, ErrNotFound)
+	case http.StatusForbidden:
+		e.kinds = append(e.kinds, ErrAccessDenied)
+	case http.StatusPreconditionFailed:
+		e.kinds = append(e.kinds, ErrPreconditionFailed)
+	case http.StatusTooManyRequests:
+		e.kinds = append(e.kinds, ErrThrottled)
+	}
+	return e
+}
@@ -0,0 +1,130 @@
+package alioss
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Predicate selecting objects of listing and bulk operations, keys of objects are relative to listed prefix
+type Filter func(ObjectInfo) bool
+
+// Get filter matching whole keys relative to listed prefix by "pattern" of path.Match, where segment "**" matches
+// any number of segments, e.g. "*.log" matches logs right under prefix and "2024/**/report-*.csv" matches reports
+// at any depth under "2024/" of prefix
+func Glob(pattern string) (Filter, error) {
+	segments := strings.Split(pattern, "/")
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return func(info ObjectInfo) bool {
+		return matchSegments(segments, strings.Split(info.Key, "/"))
+	}, nil
+}
+
+// Same as Glob, but panics on invalid pattern
+func MustGlob(pattern string) Filter {
+	filter, err := Glob(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+// Match segments of key by segments of pattern
+func matchSegments(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(key); i++ {
+				if matchSegments(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(key) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], key[0]); !matched {
+			return false
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
+
+// Get filter of objects of at least "size" bytes
+func MinSize(size int64) Filter {
+	return func(info ObjectInfo) bool {
+		return info.Size >= size
+	}
+}
+
+// Get filter of objects of at most "size" bytes
+func MaxSize(size int64) Filter {
+	return func(info ObjectInfo) bool {
+		return info.Size <= size
+	}
+}
+
+// Get filter of objects modified more than "age" ago
+func OlderThan(age time.Duration) Filter {
+	return func(info ObjectInfo) bool {
+		return time.Since(info.LastModified) > age
+	}
+}
+
+// Get filter of objects modified less than "age" ago
+func NewerThan(age time.Duration) Filter {
+	return func(info ObjectInfo) bool {
+		return time.Since(info.LastModified) < age
+	}
+}
+
+// Get filter of objects of any of storage "classes", e.g. Standard, IA, Archive
+func InStorageClass(classes ...string) Filter {
+	return func(info ObjectInfo) bool {
+		for _, class := range classes {
+			if strings.EqualFold(info.StorageClass, class) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Get filter of objects matching all "filters"
+func All(filters ...Filter) Filter {
+	return func(info ObjectInfo) bool {
+		for _, filter := range filters {
+			if !filter(info) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Get filter of objects matching any of "filters"
+func Any(filters ...Filter) Filter {
+	return func(info ObjectInfo) bool {
+		for _, filter := range filters {
+			if filter(info) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Get filter of objects not matching "filter"
+func Not(filter Filter) Filter {
+	return func(info ObjectInfo) bool {
+		return !filter(info)
+	}
+}
@@ -0,0 +1,260 @@
+package alioss
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// File system of keys under prefix of bucket.
+// Directories are common prefixes of keys and folders created by CreateFolder, files are read by ranges.
+type BucketFS struct {
+	alioss AliOss
+	ctx    context.Context
+	prefix string
+}
+
+var (
+	_ fs.ReadDirFS  = (*BucketFS)(nil)
+	_ fs.ReadFileFS = (*BucketFS)(nil)
+	_ fs.StatFS     = (*BucketFS)(nil)
+)
+
+// Get file system of keys under "prefix" of "bucket", requests are done with "ctx"
+func (alioss AliOss) FS(ctx context.Context, bucket, prefix string) *BucketFS {
+	alioss.Bucket = bucket
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &BucketFS{alioss: alioss, ctx: ctx, prefix: prefix}
+}
+
+// Open file or directory
+func (fsys *BucketFS) Open(name string) (fs.File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		entries, err := fsys.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &bucketDir{info: info, entries: entries}, nil
+	}
+
+	reader, err := fsys.alioss.Open(fsys.ctx, fsys.key(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &bucketFile{Reader: reader, info: info}, nil
+}
+
+// Get info of file or directory
+func (fsys *BucketFS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.stat("stat", name)
+}
+
+// Get entries of directory sorted by names
+func (fsys *BucketFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	prefix := fsys.dirKey(name)
+	list, err := fsys.alioss.ListAll(fsys.ctx, prefix)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if len(list) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(list))
+	for _, info := range list {
+		if info.Key == prefix { // Marker of folder created by CreateFolder
+			continue
+		}
+		entryName := strings.TrimSuffix(strings.TrimPrefix(info.Key, prefix), "/")
+		if !fs.ValidPath(entryName) || strings.Contains(entryName, "/") {
+			continue // Key like "a//b" can't be a path
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(objectFileInfo{info: info, name: entryName}))
+	}
+	// Keys are listed in lexical order, where "dir-1.txt" precedes "dir/", but entry "dir" follows "dir-1.txt".
+	// File and directory with the same name are one entry, file takes precedence like in stat.
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		if name := strings.Compare(a.Name(), b.Name()); name != 0 {
+			return name
+		}
+		if a.IsDir() == b.IsDir() {
+			return 0
+		}
+		if b.IsDir() {
+			return -1
+		}
+		return 1
+	})
+	entries = slices.CompactFunc(entries, func(a, b fs.DirEntry) bool {
+		return a.Name() == b.Name()
+	})
+	return entries, nil
+}
+
+// Read whole file
+func (fsys *BucketFS) ReadFile(name string) ([]byte, error) {
+	info, err := fsys.stat("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+
+	body, err := fsys.alioss.backend().GetObject(fsys.ctx, fsys.alioss.Bucket, fsys.key(name), 0, -1, info.Sys().(ObjectInfo).ETag)
+	if isPreconditionFailed(err) {
+		err = ErrObjectChanged
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	defer fsys.alioss.IoClose(body)
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// Get info of file or directory, files take precedence over directories with the same name
+func (fsys *BucketFS) stat(op, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return objectFileInfo{info: ObjectInfo{Key: fsys.prefix, IsPrefix: true}, name: "."}, nil
+	}
+
+	info, err := fsys.alioss.StatContext(fsys.ctx, fsys.key(name))
+	if err == nil {
+		return objectFileInfo{info: info, name: path.Base(name)}, nil
+	}
+	if !isNotFound(err) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	prefix := fsys.dirKey(name)
+	for _, err := range fsys.alioss.List(fsys.ctx, prefix) {
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		return objectFileInfo{info: ObjectInfo{Key: prefix, IsPrefix: true}, name: path.Base(name)}, nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (fsys *BucketFS) key(name string) string {
+	return fsys.prefix + name
+}
+
+// Get prefix of keys of directory
+func (fsys *BucketFS) dirKey(name string) string {
+	if name == "." {
+		return fsys.prefix
+	}
+	return fsys.prefix + name + "/"
+}
+
+// Info of object or prefix as file or directory
+type objectFileInfo struct {
+	info ObjectInfo
+	name string
+}
+
+func (fi objectFileInfo) Name() string {
+	return fi.name
+}
+
+func (fi objectFileInfo) Size() int64 {
+	return fi.info.Size
+}
+
+func (fi objectFileInfo) Mode() fs.FileMode {
+	if fi.info.IsPrefix {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// Listing and HEAD report modification time with different precision, so it's truncated to seconds
+func (fi objectFileInfo) ModTime() time.Time {
+	return fi.info.LastModified.Truncate(time.Second).UTC()
+}
+
+func (fi objectFileInfo) IsDir() bool {
+	return fi.info.IsPrefix
+}
+
+// Get ObjectInfo
+func (fi objectFileInfo) Sys() any {
+	return fi.info
+}
+
+// Opened file
+type bucketFile struct {
+	*Reader
+	info fs.FileInfo
+}
+
+func (f *bucketFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Opened directory with entries listed on open
+type bucketDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+	closed  bool
+}
+
+func (d *bucketDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *bucketDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *bucketDir) Close() error {
+	if d.closed {
+		return fs.ErrClosed
+	}
+	d.closed = true
+	return nil
+}
+
+// Get next "n" entries, all remaining entries if "n" isn't positive
+func (d *bucketDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, fs.ErrClosed
+	}
+
+	entries := d.entries[d.offset:]
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(entries) {
+		entries = entries[:n]
+	}
+	d.offset += len(entries)
+	return entries, nil
+}
@@ -0,0 +1,120 @@
+package alioss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Failure of single part of transfer
+type PartError struct {
+	PartNumber int
+	Err        error
+}
+
+func (e *PartError) Error() string {
+	return fmt.Sprintf("part number %d: %s", e.PartNumber, e.Err)
+}
+
+func (e *PartError) Unwrap() error {
+	return e.Err
+}
+
+// Failures of parts of transfer ordered by part numbers
+type PartsError []*PartError
+
+func (e PartsError) Error() string {
+	numbers := make([]string, len(e))
+	messages := make([]string, len(e))
+	for i, partErr := range e {
+		numbers[i] = fmt.Sprint(partErr.PartNumber)
+		messages[i] = partErr.Error()
+	}
+	return fmt.Sprintf("Failed parts %s: %s", strings.Join(numbers, ", "), strings.Join(messages, "; "))
+}
+
+// Get part errors for errors.Is and errors.As
+func (e PartsError) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, partErr := range e {
+		errs[i] = partErr
+	}
+	return errs
+}
+
+// Coordinator of workers of transfer, which cancels remaining parts on first failure
+type partGroup struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	failed   PartsError
+	firstErr error // Failure outside of parts, e.g. reading of file
+}
+
+// Get group and its context, which is canceled on first failure or cancel of "ctx"
+func newPartGroup(ctx context.Context) (*partGroup, context.Context) {
+	groupCtx, cancel := context.WithCancel(ctx)
+	return &partGroup{parent: ctx, ctx: groupCtx, cancel: cancel}, groupCtx
+}
+
+// Run worker, its error fails group
+func (g *partGroup) Go(worker func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := worker(); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+// Record failure of part and cancel remaining parts.
+// Parts interrupted by cancel of group aren't recorded.
+func (g *partGroup) failPart(partNumber int, err error) {
+	if g.ctx.Err() != nil && errors.Is(err, context.Canceled) {
+		return
+	}
+
+	g.mu.Lock()
+	g.failed = append(g.failed, &PartError{PartNumber: partNumber, Err: err})
+	g.mu.Unlock()
+	g.cancel()
+}
+
+// Record failure outside of parts and cancel remaining parts
+func (g *partGroup) fail(err error) {
+	if g.ctx.Err() != nil && errors.Is(err, g.ctx.Err()) {
+		return
+	}
+
+	g.mu.Lock()
+	if g.firstErr == nil {
+		g.firstErr = err
+	}
+	g.mu.Unlock()
+	g.cancel()
+}
+
+// Wait for all workers. Cancel of parent context is reported as is,
+// otherwise failed parts are reported before other failures.
+func (g *partGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+
+	if g.parent.Err() != nil {
+		return g.parent.Err()
+	}
+	if len(g.failed) > 0 {
+		slices.SortFunc(g.failed, func(a, b *PartError) int {
+			return a.PartNumber - b.PartNumber
+		})
+		return g.failed
+	}
+	return g.firstErr
+}
@@ -0,0 +1,89 @@
+package alioss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const metaHeaderPrefix = "X-Oss-Meta-"
+
+// Metadata of remote object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string // Quoted as returned by OSS
+	LastModified time.Time
+	ContentType  string
+	StorageClass string            // Standard, IA, Archive, ColdArchive
+	CRC64        uint64            // CRC-64/ECMA of content, zero if it's unknown
+	VersionID    string            // Empty if versioning of bucket is disabled
+	ObjectType   string            // Normal, Multipart, Appendable
+	Metadata     map[string]string // User metadata by lowercase names without X-Oss-Meta- prefix, only Stat gets it
+	IsPrefix     bool              // Sub-prefix of listing, like folder
+}
+
+// Get metadata of remote "key", missing key is reported as ErrNotFound
+func (alioss AliOss) Stat(key string) (ObjectInfo, error) {
+	return alioss.StatContext(context.Background(), key)
+}
+
+// Same as Stat with context
+func (alioss AliOss) StatContext(ctx context.Context, key string) (ObjectInfo, error) {
+	key = strings.TrimPrefix(key, "/")
+
+	headers, err := alioss.backend().HeadObject(ctx, alioss.Bucket, key)
+	if err != nil {
+		alioss.Log.Printf("Failed to stat key %s: %s\n", key, err)
+		return ObjectInfo{}, fmt.Errorf("Failed to stat key %s: %w", key, err)
+	}
+
+	info, err := objectInfo(key, headers)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("Failed to stat key %s: %w", key, err)
+	}
+	return info, nil
+}
+
+// Get metadata of "key" from headers of HEAD response
+func objectInfo(key string, headers http.Header) (info ObjectInfo, err error) {
+	info = ObjectInfo{
+		Key:          key,
+		ETag:         headers.Get("ETag"),
+		ContentType:  headers.Get("Content-Type"),
+		StorageClass: headers.Get("X-Oss-Storage-Class"),
+		VersionID:    headers.Get("X-Oss-Version-Id"),
+		ObjectType:   headers.Get("X-Oss-Object-Type"),
+	}
+
+	info.Size, err = strconv.ParseInt(headers.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return info, fmt.Errorf("Invalid header Content-Length: %w", err)
+	}
+	if lastModified := headers.Get("Last-Modified"); lastModified != "" {
+		info.LastModified, err = http.ParseTime(lastModified)
+		if err != nil {
+			return info, fmt.Errorf("Invalid header Last-Modified: %w", err)
+		}
+	}
+	if crc := headers.Get("X-Oss-Hash-Crc64ecma"); crc != "" {
+		info.CRC64, err = strconv.ParseUint(crc, 10, 64)
+		if err != nil {
+			return info, fmt.Errorf("Invalid header X-Oss-Hash-Crc64ecma: %w", err)
+		}
+	}
+
+	for name, values := range headers {
+		name = http.CanonicalHeaderKey(name)
+		if strings.HasPrefix(name, metaHeaderPrefix) && len(values) > 0 {
+			if info.Metadata == nil {
+				info.Metadata = make(map[string]string)
+			}
+			info.Metadata[strings.ToLower(strings.TrimPrefix(name, metaHeaderPrefix))] = values[0]
+		}
+	}
+	return info, nil
+}
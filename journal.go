@@ -0,0 +1,96 @@
+package alioss
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// Suffix of journal of resumed download next to destination file
+const journalSuffix = ".alioss-resume"
+
+// Journal of resumed download
+type downloadJournal struct {
+	path string
+
+	ETag         string
+	LastModified string
+	Size         int64
+	PartSize     int64
+	Completed    []journalRange // Sorted and merged downloaded ranges
+}
+
+// Range of downloaded bytes
+type journalRange struct {
+	Offset int64
+	Length int64
+}
+
+// Read journal from "path"
+func loadDownloadJournal(path string) (*downloadJournal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &downloadJournal{}
+	err = json.Unmarshal(data, journal)
+	if err != nil {
+		return nil, err
+	}
+	journal.path = path
+	return journal, nil
+}
+
+// Check that journal is written for the same version of remote file
+func (journal *downloadJournal) matches(remote *downloadJournal) bool {
+	return journal.ETag == remote.ETag &&
+		journal.LastModified == remote.LastModified &&
+		journal.Size == remote.Size &&
+		journal.PartSize > 0
+}
+
+// Record downloaded range
+func (journal *downloadJournal) add(offset, length int64) {
+	if length <= 0 {
+		return
+	}
+
+	ranges := append(journal.Completed, journalRange{Offset: offset, Length: length})
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Offset < ranges[j].Offset
+	})
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Offset <= last.Offset+last.Length {
+			last.Length = max(last.Length, r.Offset+r.Length-last.Offset)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	journal.Completed = merged
+}
+
+// Split ranges of remote "key", which are not downloaded yet, into parts
+func (journal *downloadJournal) missingParts(key string) (parts []filePart) {
+	var offset int64
+	for _, r := range append(journal.Completed, journalRange{Offset: journal.Size}) {
+		if end := min(r.Offset, journal.Size); end > offset {
+			parts = append(parts, splitParts(key, journal.ETag, offset, end, journal.PartSize)...)
+		}
+		offset = max(offset, r.Offset+r.Length)
+	}
+	return
+}
+
+// Write journal atomically, so crash leaves either previous or new version
+func (journal *downloadJournal) save() error {
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(journal.path, data)
+}
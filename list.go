@@ -0,0 +1,176 @@
+package alioss
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// Parameters of listing
+type listConfig struct {
+	prefix      string // Listed prefix, filters get keys relative to it
+	recursive   bool
+	concurrency int
+	include     []Filter
+	exclude     []Filter
+}
+
+// Option of listing
+type ListOption func(*listConfig)
+
+// List all keys under prefix instead of grouping keys of sub-folders into sub-prefixes
+func WithRecursive() ListOption {
+	return func(cfg *listConfig) {
+		cfg.recursive = true
+	}
+}
+
+// Set number of parallel requests of walk and bulk operations
+func WithListConcurrency(concurrency int) ListOption {
+	return func(cfg *listConfig) {
+		cfg.concurrency = concurrency
+	}
+}
+
+// Select only objects matching any of "filters", sub-prefixes aren't filtered.
+// Filters get keys relative to listed prefix, e.g. "*.log" selects "logs/a.log" of listing of "logs".
+func WithInclude(filters ...Filter) ListOption {
+	return func(cfg *listConfig) {
+		cfg.include = append(cfg.include, filters...)
+	}
+}
+
+// Skip objects matching any of "filters", sub-prefixes aren't filtered.
+// Filters get keys relative to listed prefix.
+func WithExclude(filters ...Filter) ListOption {
+	return func(cfg *listConfig) {
+		cfg.exclude = append(cfg.exclude, filters...)
+	}
+}
+
+// Get config of listing of "prefix" from options
+func newListConfig(prefix string, opts []ListOption) listConfig {
+	cfg := listConfig{prefix: strings.TrimPrefix(prefix, "/"), concurrency: 1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.concurrency = max(cfg.concurrency, 1)
+	return cfg
+}
+
+// Get prefix of keys of folder "prefix" without leading "/" and with trailing "/", empty prefix is root of bucket
+func folderPrefix(prefix string) string {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// Check that object is selected by include and exclude filters
+func (cfg listConfig) matches(info ObjectInfo) bool {
+	info.Key = strings.TrimPrefix(strings.TrimPrefix(info.Key, cfg.prefix), "/")
+	for _, filter := range cfg.exclude {
+		if filter(info) {
+			return false
+		}
+	}
+	for _, filter := range cfg.include {
+		if filter(info) {
+			return true
+		}
+	}
+	return len(cfg.include) == 0
+}
+
+// Iterate objects and sub-prefixes under "prefix" in lexical order, pages of listing are requested on demand.
+// Sub-prefixes end with "/" and have only Key and IsPrefix set, recursive listing has no sub-prefixes.
+// Iteration stops after the first error.
+func (alioss AliOss) List(ctx context.Context, prefix string, opts ...ListOption) iter.Seq2[ObjectInfo, error] {
+	return alioss.list(ctx, prefix, newListConfig(prefix, opts))
+}
+
+func (alioss AliOss) list(ctx context.Context, prefix string, cfg listConfig) iter.Seq2[ObjectInfo, error] {
+	delimiter := "/"
+	if cfg.recursive {
+		delimiter = ""
+	}
+
+	return func(yield func(ObjectInfo, error) bool) {
+		for page, err := range alioss.listPages(ctx, strings.TrimPrefix(prefix, "/"), delimiter) {
+			if err != nil {
+				yield(ObjectInfo{}, err)
+				return
+			}
+
+			objects, prefixes := page.Objects, page.CommonPrefixes
+			for len(objects) > 0 || len(prefixes) > 0 {
+				var info ObjectInfo
+				if len(prefixes) == 0 || len(objects) > 0 && objects[0].Key < prefixes[0] {
+					info, objects = listedObjectInfo(objects[0]), objects[1:]
+				} else {
+					info, prefixes = ObjectInfo{Key: prefixes[0], IsPrefix: true}, prefixes[1:]
+				}
+				if !info.IsPrefix && !cfg.matches(info) {
+					continue
+				}
+				if !yield(info, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Get all objects and sub-prefixes under "prefix", see List
+func (alioss AliOss) ListAll(ctx context.Context, prefix string, opts ...ListOption) ([]ObjectInfo, error) {
+	return alioss.listAll(ctx, prefix, newListConfig(prefix, opts))
+}
+
+func (alioss AliOss) listAll(ctx context.Context, prefix string, cfg listConfig) (list []ObjectInfo, err error) {
+	for info, err := range alioss.list(ctx, prefix, cfg) {
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+// Iterate pages of listing of "prefix" following next markers
+func (alioss AliOss) listPages(ctx context.Context, prefix, delimiter string) iter.Seq2[oss.ListObjectsResult, error] {
+	return func(yield func(oss.ListObjectsResult, error) bool) {
+		marker := ""
+		for {
+			page, err := alioss.backend().ListObjects(ctx, alioss.Bucket, prefix, delimiter, marker)
+			if err != nil {
+				alioss.Log.Printf("Failed to list objects in /%s after %q: %s\n", prefix, marker, err)
+				yield(page, fmt.Errorf("Failed to list objects in /%s: %w", prefix, err))
+				return
+			}
+			if !yield(page, nil) || !page.IsTruncated {
+				return
+			}
+			if page.NextMarker == "" || page.NextMarker <= marker {
+				yield(oss.ListObjectsResult{}, fmt.Errorf("Failed to list objects in /%s: invalid next marker %q after %q", prefix, page.NextMarker, marker))
+				return
+			}
+			marker = page.NextMarker
+		}
+	}
+}
+
+// Get metadata of listed object
+func listedObjectInfo(object oss.ObjectProperties) ObjectInfo {
+	return ObjectInfo{
+		Key:          object.Key,
+		Size:         object.Size,
+		ETag:         object.ETag,
+		LastModified: object.LastModified,
+		StorageClass: object.StorageClass,
+		ObjectType:   object.Type,
+	}
+}
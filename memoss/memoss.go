@@ -0,0 +1,586 @@
+// Package memoss is an in-memory implementation of alioss.Backend
+// for testing code built on alioss without Aliyun OSS account
+package memoss
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+const (
+	DefaultMaxKeys    int   = 100
+	MaxDeleteKeys     int   = 1000 // Number of keys deleted by one request
+	DefaultMaxUploads int   = 1000
+	DefaultMaxParts   int   = 1000
+	MinPartSize       int64 = 100 * 1024 // 100Kb, except last part
+	MaxPartNumber     int   = 10000
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// In-memory storage of buckets, objects and multipart uploads.
+// Zero page sizes are replaced by defaults of OSS.
+type Backend struct {
+	MaxKeys    int
+	MaxUploads int
+	MaxParts   int
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	uploadNo int
+}
+
+type bucket struct {
+	objects map[string]*object
+	uploads map[string]*upload
+}
+
+type object struct {
+	data         []byte
+	etag         string
+	objectType   string
+	lastModified time.Time
+}
+
+type upload struct {
+	key       string
+	uploadId  string
+	initiated time.Time
+	parts     map[int]*object
+}
+
+// Create empty storage
+func New() *Backend {
+	return &Backend{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Get copy of object data, for assertions in tests
+func (b *Backend) Object(bucketName, key string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, ok := b.buckets[bucketName]
+	if !ok {
+		return nil, false
+	}
+	obj, ok := bkt.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+func (b *Backend) ListBuckets(ctx context.Context) ([]string, error) {
+	err := b.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+
+	list := make([]string, 0, len(b.buckets))
+	for name := range b.buckets {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (b *Backend) CreateBucket(ctx context.Context, bucketName string) error {
+	err := b.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	if bucketName == "" {
+		return serviceError(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+	if _, ok := b.buckets[bucketName]; !ok {
+		b.buckets[bucketName] = &bucket{
+			objects: make(map[string]*object),
+			uploads: make(map[string]*upload),
+		}
+	}
+	return nil
+}
+
+func (b *Backend) ListObjects(ctx context.Context, bucketName, prefix, delimiter, marker string) (result oss.ListObjectsResult, err error) {
+	err = b.lock(ctx)
+	if err != nil {
+		return
+	}
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return
+	}
+
+	result = oss.ListObjectsResult{
+		Prefix:    prefix,
+		Marker:    marker,
+		MaxKeys:   pageSize(b.MaxKeys, DefaultMaxKeys),
+		Delimiter: delimiter,
+	}
+
+	keys := make([]string, 0, len(bkt.objects))
+	for key := range bkt.objects {
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if commonPrefix != "" && len(result.CommonPrefixes) > 0 && result.CommonPrefixes[len(result.CommonPrefixes)-1] == commonPrefix {
+			continue
+		}
+		if commonPrefix != "" && commonPrefix <= marker {
+			continue
+		}
+
+		if len(result.Objects)+len(result.CommonPrefixes) == result.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+			result.NextMarker = commonPrefix
+			continue
+		}
+
+		obj := bkt.objects[key]
+		result.Objects = append(result.Objects, oss.ObjectProperties{
+			Key:          key,
+			Type:         obj.objectType,
+			Size:         int64(len(obj.data)),
+			ETag:         obj.etag,
+			LastModified: obj.lastModified,
+			StorageClass: "Standard",
+		})
+		result.NextMarker = key
+	}
+
+	if !result.IsTruncated {
+		result.NextMarker = ""
+	}
+	return
+}
+
+func (b *Backend) HeadObject(ctx context.Context, bucketName, key string) (http.Header, error) {
+	err := b.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+
+	obj, err := b.object(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.headers(), nil
+}
+
+func (b *Backend) GetObject(ctx context.Context, bucketName, key string, start, end int64, ifMatch string) (io.ReadCloser, error) {
+	err := b.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer b.mu.Unlock()
+
+	obj, err := b.object(bucketName, key)
+	if err != nil {
+		return nil, err
+	}
+	if ifMatch != "" && ifMatch != obj.etag {
+		return nil, serviceError(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold.")
+	}
+
+	size := int64(len(obj.data))
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	if start < 0 || (start > end && !(start == 0 && size == 0)) {
+		return nil, serviceError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range cannot be satisfied.")
+	}
+
+	return io.NopCloser(bytes.NewReader(obj.data[start : end+1])), nil
+}
+
+func (b *Backend) PutObject(ctx context.Context, bucketName, key string, reader io.Reader) error {
+	data, err := readAll(reader)
+	if err != nil {
+		return err
+	}
+
+	err = b.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+
+	bkt.objects[key] = newObject(data, "Normal")
+	return nil
+}
+
+func (b *Backend) DeleteObject(ctx context.Context, bucketName, key string) error {
+	err := b.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+
+	delete(bkt.objects, key)
+	return nil
+}
+
+func (b *Backend) DeleteObjects(ctx context.Context, bucketName string, keys []string) error {
+	if len(keys) == 0 || len(keys) > MaxDeleteKeys {
+		return serviceError(http.StatusBadRequest, "MalformedXML", fmt.Sprintf("Number of objects to delete must be between 1 and %d.", MaxDeleteKeys))
+	}
+
+	err := b.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		delete(bkt.objects, key)
+	}
+	return nil
+}
+
+func (b *Backend) InitiateMultipartUpload(ctx context.Context, bucketName, key string) (string, error) {
+	err := b.lock(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+
+	b.uploadNo++
+	uploadId := fmt.Sprintf("%032X", b.uploadNo)
+	bkt.uploads[uploadId] = &upload{
+		key:       key,
+		uploadId:  uploadId,
+		initiated: time.Now().UTC(),
+		parts:     make(map[int]*object),
+	}
+	return uploadId, nil
+}
+
+func (b *Backend) UploadPart(ctx context.Context, bucketName, key, uploadId string, partNumber int, reader io.Reader, size int64) (part oss.UploadPart, err error) {
+	if partNumber < 1 || partNumber > MaxPartNumber {
+		err = serviceError(http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive.")
+		return
+	}
+
+	data, err := readAll(io.LimitReader(reader, size))
+	if err != nil {
+		return
+	}
+
+	err = b.lock(ctx)
+	if err != nil {
+		return
+	}
+	defer b.mu.Unlock()
+
+	upl, err := b.upload(bucketName, key, uploadId)
+	if err != nil {
+		return
+	}
+
+	obj := newObject(data, "")
+	upl.parts[partNumber] = obj
+	return oss.UploadPart{PartNumber: partNumber, ETag: obj.etag}, nil
+}
+
+func (b *Backend) ListMultipartUploads(ctx context.Context, bucketName, prefix, keyMarker, uploadIdMarker string) (result oss.ListMultipartUploadResult, err error) {
+	err = b.lock(ctx)
+	if err != nil {
+		return
+	}
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return
+	}
+
+	result = oss.ListMultipartUploadResult{
+		Bucket:         bucketName,
+		Prefix:         prefix,
+		KeyMarker:      keyMarker,
+		UploadIDMarker: uploadIdMarker,
+		MaxUploads:     pageSize(b.MaxUploads, DefaultMaxUploads),
+	}
+
+	var uploads []*upload
+	for _, upl := range bkt.uploads {
+		if !strings.HasPrefix(upl.key, prefix) {
+			continue
+		}
+		if upl.key < keyMarker || (upl.key == keyMarker && (uploadIdMarker == "" || upl.uploadId <= uploadIdMarker)) {
+			continue
+		}
+		uploads = append(uploads, upl)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].key != uploads[j].key {
+			return uploads[i].key < uploads[j].key
+		}
+		return uploads[i].uploadId < uploads[j].uploadId
+	})
+
+	for _, upl := range uploads {
+		if len(result.Uploads) == result.MaxUploads {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, oss.UncompletedUpload{
+			Key:       upl.key,
+			UploadID:  upl.uploadId,
+			Initiated: upl.initiated,
+		})
+		result.NextKeyMarker = upl.key
+		result.NextUploadIDMarker = upl.uploadId
+	}
+	return
+}
+
+func (b *Backend) ListUploadedParts(ctx context.Context, bucketName, key, uploadId string, partNumberMarker int) (result oss.ListUploadedPartsResult, err error) {
+	err = b.lock(ctx)
+	if err != nil {
+		return
+	}
+	defer b.mu.Unlock()
+
+	upl, err := b.upload(bucketName, key, uploadId)
+	if err != nil {
+		return
+	}
+
+	result = oss.ListUploadedPartsResult{
+		Bucket:   bucketName,
+		Key:      key,
+		UploadID: uploadId,
+		MaxParts: pageSize(b.MaxParts, DefaultMaxParts),
+	}
+
+	for _, partNumber := range upl.partNumbers() {
+		if partNumber <= partNumberMarker {
+			continue
+		}
+		if len(result.UploadedParts) == result.MaxParts {
+			result.IsTruncated = true
+			break
+		}
+		part := upl.parts[partNumber]
+		result.UploadedParts = append(result.UploadedParts, oss.UploadedPart{
+			PartNumber:   partNumber,
+			LastModified: part.lastModified,
+			ETag:         part.etag,
+			Size:         len(part.data),
+		})
+		result.NextPartNumberMarker = strconv.Itoa(partNumber)
+	}
+	return
+}
+
+func (b *Backend) CompleteMultipartUpload(ctx context.Context, bucketName, key, uploadId string, parts []oss.UploadPart) error {
+	err := b.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	upl, err := b.upload(bucketName, key, uploadId)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return serviceError(http.StatusBadRequest, "InvalidDigest", "The list of parts is empty.")
+	}
+
+	var data []byte
+	var etags []byte
+	for i, completed := range parts {
+		if i > 0 && completed.PartNumber <= parts[i-1].PartNumber {
+			return serviceError(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+		}
+		part, ok := upl.parts[completed.PartNumber]
+		if !ok || !strings.EqualFold(strings.Trim(part.etag, `"`), strings.Trim(completed.ETag, `"`)) {
+			return serviceError(http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part number %d cannot be found or ETag mismatch.", completed.PartNumber))
+		}
+		if i < len(parts)-1 && int64(len(part.data)) < MinPartSize {
+			return serviceError(http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("Part number %d is smaller than the minimum allowed size.", completed.PartNumber))
+		}
+		data = append(data, part.data...)
+		sum, _ := hex.DecodeString(strings.Trim(part.etag, `"`))
+		etags = append(etags, sum...)
+	}
+
+	obj := newObject(data, "Multipart")
+	sum := md5.Sum(etags)
+	obj.etag = fmt.Sprintf("\"%s-%d\"", strings.ToUpper(hex.EncodeToString(sum[:])), len(parts))
+
+	bkt := b.buckets[bucketName]
+	bkt.objects[key] = obj
+	delete(bkt.uploads, uploadId)
+	return nil
+}
+
+func (b *Backend) AbortMultipartUpload(ctx context.Context, bucketName, key, uploadId string) error {
+	err := b.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.mu.Unlock()
+
+	_, err = b.upload(bucketName, key, uploadId)
+	if err != nil {
+		return err
+	}
+
+	delete(b.buckets[bucketName].uploads, uploadId)
+	return nil
+}
+
+// Lock storage unless context is done
+func (b *Backend) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	return nil
+}
+
+func (b *Backend) bucket(bucketName string) (*bucket, error) {
+	bkt, ok := b.buckets[bucketName]
+	if !ok {
+		return nil, serviceError(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+	}
+	return bkt, nil
+}
+
+func (b *Backend) object(bucketName, key string) (*object, error) {
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := bkt.objects[key]
+	if !ok {
+		return nil, serviceError(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	}
+	return obj, nil
+}
+
+func (b *Backend) upload(bucketName, key, uploadId string) (*upload, error) {
+	bkt, err := b.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	upl, ok := bkt.uploads[uploadId]
+	if !ok || upl.key != key {
+		return nil, serviceError(http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+	}
+	return upl, nil
+}
+
+func (upl *upload) partNumbers() []int {
+	numbers := make([]int, 0, len(upl.parts))
+	for partNumber := range upl.parts {
+		numbers = append(numbers, partNumber)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+func newObject(data []byte, objectType string) *object {
+	sum := md5.Sum(data)
+	return &object{
+		data:         data,
+		etag:         fmt.Sprintf("\"%s\"", strings.ToUpper(hex.EncodeToString(sum[:]))),
+		objectType:   objectType,
+		lastModified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// Object metadata as OSS returns it in HEAD response
+func (obj *object) headers() http.Header {
+	headers := make(http.Header)
+	headers.Set("Content-Type", "application/octet-stream")
+	headers.Set("Content-Length", strconv.Itoa(len(obj.data)))
+	headers.Set("ETag", obj.etag)
+	headers.Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	headers.Set("X-Oss-Object-Type", obj.objectType)
+	headers.Set("X-Oss-Storage-Class", "Standard")
+	headers.Set("X-Oss-Hash-Crc64ecma", strconv.FormatUint(crc64.Checksum(obj.data, crcTable), 10))
+	return headers
+}
+
+func readAll(reader io.Reader) ([]byte, error) {
+	if reader == nil {
+		return []byte{}, nil
+	}
+	return io.ReadAll(reader)
+}
+
+func pageSize(size, defaultSize int) int {
+	if size <= 0 {
+		return defaultSize
+	}
+	return size
+}
+
+func serviceError(statusCode int, code, message string) error {
+	return oss.ServiceError{
+		Code:       code,
+		Message:    message,
+		StatusCode: statusCode,
+	}
+}
@@ -0,0 +1,212 @@
+package memoss_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/oneumyvakin/alioss"
+	"github.com/oneumyvakin/alioss/memoss"
+)
+
+var _ alioss.Backend = memoss.New()
+
+func TestObjects(t *testing.T) {
+	ctx := context.Background()
+	backend := memoss.New()
+	backend.MaxKeys = 2
+	if err := backend.CreateBucket(ctx, "bucket"); err != nil {
+		t.Fatalf("Failed to create bucket: %s", err)
+	}
+
+	for _, key := range []string{"a.txt", "dir/", "dir/b.txt", "dir/c.txt", "dir/sub/d.txt", "e.txt"} {
+		if err := backend.PutObject(ctx, "bucket", key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatalf("Failed to put %s: %s", key, err)
+		}
+	}
+
+	var keys, prefixes []string
+	marker := ""
+	for {
+		result, err := backend.ListObjects(ctx, "bucket", "dir/", "/", marker)
+		if err != nil {
+			t.Fatalf("Failed to list objects: %s", err)
+		}
+		for _, object := range result.Objects {
+			keys = append(keys, object.Key)
+		}
+		prefixes = append(prefixes, result.CommonPrefixes...)
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextMarker
+	}
+	if want := []string{"dir/", "dir/b.txt", "dir/c.txt"}; !equal(keys, want) {
+		t.Fatalf("Failed to list objects: %v != %v", keys, want)
+	}
+	if want := []string{"dir/sub/"}; !equal(prefixes, want) {
+		t.Fatalf("Failed to list common prefixes: %v != %v", prefixes, want)
+	}
+
+	body, err := backend.GetObject(ctx, "bucket", "dir/b.txt", 4, 6, "")
+	if err != nil {
+		t.Fatalf("Failed to get range: %s", err)
+	}
+	data, _ := io.ReadAll(body)
+	if string(data) != "b.t" {
+		t.Fatalf("Failed to get range: %q", data)
+	}
+
+	headers, err := backend.HeadObject(ctx, "bucket", "dir/b.txt")
+	if err != nil {
+		t.Fatalf("Failed to head object: %s", err)
+	}
+	if headers.Get("Content-Length") != "9" || headers.Get("ETag") == "" {
+		t.Fatalf("Failed to head object: %v", headers)
+	}
+
+	if _, err := backend.HeadObject(ctx, "bucket", "missing"); err == nil {
+		t.Fatal("Failed to report missing object")
+	}
+
+	if _, err := backend.GetObject(ctx, "bucket", "dir/b.txt", 0, -1, headers.Get("ETag")); err != nil {
+		t.Fatalf("Failed to get object with matching ETag: %s", err)
+	}
+	_, err = backend.GetObject(ctx, "bucket", "dir/b.txt", 0, -1, "\"other\"")
+	var serviceErr oss.ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("Failed to reject mismatched ETag: %v", err)
+	}
+
+	if err := backend.DeleteObjects(ctx, "bucket", make([]string, memoss.MaxDeleteKeys+1)); err == nil {
+		t.Fatal("Failed to limit number of deleted objects")
+	}
+	if err := backend.DeleteObjects(ctx, "bucket", []string{"a.txt", "dir/b.txt", "missing"}); err != nil {
+		t.Fatalf("Failed to delete objects: %s", err)
+	}
+	if _, err := backend.HeadObject(ctx, "bucket", "dir/b.txt"); err == nil {
+		t.Fatal("Failed to delete objects")
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	backend := memoss.New()
+	backend.MaxParts = 1
+	_ = backend.CreateBucket(ctx, "bucket")
+
+	uploadId, err := backend.InitiateMultipartUpload(ctx, "bucket", "key")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %s", err)
+	}
+
+	small := []byte("too small part")
+	part1, err := backend.UploadPart(ctx, "bucket", "key", uploadId, 1, bytes.NewReader(small), int64(len(small)))
+	if err != nil {
+		t.Fatalf("Failed to upload part: %s", err)
+	}
+	part2, err := backend.UploadPart(ctx, "bucket", "key", uploadId, 2, bytes.NewReader(small), int64(len(small)))
+	if err != nil {
+		t.Fatalf("Failed to upload part: %s", err)
+	}
+
+	result, err := backend.ListUploadedParts(ctx, "bucket", "key", uploadId, 0)
+	if err != nil || len(result.UploadedParts) != 1 || !result.IsTruncated {
+		t.Fatalf("Failed to list first page of parts: %v %s", result, err)
+	}
+	result, err = backend.ListUploadedParts(ctx, "bucket", "key", uploadId, 1)
+	if err != nil || len(result.UploadedParts) != 1 || result.UploadedParts[0].PartNumber != 2 {
+		t.Fatalf("Failed to list second page of parts: %v %s", result, err)
+	}
+
+	err = backend.CompleteMultipartUpload(ctx, "bucket", "key", uploadId, []oss.UploadPart{part1, part2})
+	if err == nil {
+		t.Fatal("Failed to reject too small part")
+	}
+
+	err = backend.AbortMultipartUpload(ctx, "bucket", "key", uploadId)
+	if err != nil {
+		t.Fatalf("Failed to abort upload: %s", err)
+	}
+	uploads, err := backend.ListMultipartUploads(ctx, "bucket", "", "", "")
+	if err != nil || len(uploads.Uploads) != 0 {
+		t.Fatalf("Failed to abort upload: %v %s", uploads, err)
+	}
+}
+
+func TestAliOss(t *testing.T) {
+	ctx := context.Background()
+	backend := memoss.New()
+	_ = backend.CreateBucket(ctx, "bucket")
+	aliSvc := alioss.AliOss{
+		Log:     log.New(io.Discard, "", 0),
+		Backend: backend,
+		Bucket:  "bucket",
+	}
+
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "test.bin")
+	data := make([]byte, 2*alioss.DefaultUploadPartSize+1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(testFile, data, 0660); err != nil {
+		t.Fatalf("Failed to write test file: %s", err)
+	}
+
+	if err := aliSvc.Upload(testFile, "/folder"); err != nil {
+		t.Fatalf("Failed to upload: %s", err)
+	}
+	if uploaded, _ := backend.Object("bucket", "folder/test.bin"); !bytes.Equal(uploaded, data) {
+		t.Fatal("Failed to match uploaded data")
+	}
+
+	list, err := aliSvc.GetBucketFilesList("folder")
+	if err != nil || len(list) != 1 || list[0].Key != "folder/test.bin" {
+		t.Fatalf("Failed to list uploaded file: %v %s", list, err)
+	}
+
+	uploadId, err := backend.InitiateMultipartUpload(ctx, "bucket", "resumed.bin")
+	if err != nil {
+		t.Fatalf("Failed to initiate upload: %s", err)
+	}
+	part := data[:alioss.DefaultUploadPartSize]
+	if _, err := backend.UploadPart(ctx, "bucket", "resumed.bin", uploadId, 1, bytes.NewReader(part), int64(len(part))); err != nil {
+		t.Fatalf("Failed to upload first part: %s", err)
+	}
+	if err := aliSvc.ResumeUpload(testFile, "resumed.bin", uploadId); err != nil {
+		t.Fatalf("Failed to resume upload: %s", err)
+	}
+	if uploaded, _ := backend.Object("bucket", "resumed.bin"); !bytes.Equal(uploaded, data) {
+		t.Fatal("Failed to match resumed data")
+	}
+
+	downloaded := filepath.Join(dir, "test.bin.downloaded")
+	if err := os.WriteFile(downloaded, data[:1000], 0660); err != nil {
+		t.Fatalf("Failed to write partial download: %s", err)
+	}
+	if err := aliSvc.ResumeDownload("resumed.bin", downloaded); err != nil {
+		t.Fatalf("Failed to resume download: %s", err)
+	}
+	if got, _ := os.ReadFile(downloaded); !bytes.Equal(got, data) {
+		t.Fatal("Failed to match downloaded data")
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,495 @@
+// Package osstest provides local HTTP server speaking enough of Aliyun OSS REST protocol
+// for end-to-end tests of code using OSS SDK client created with oss.New(server.URL, ...)
+package osstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/oneumyvakin/alioss/memoss"
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// OSS emulator on local address, SDK uses path style URLs like /bucket/key for IP endpoints.
+// Requests are not authenticated, any access key is accepted.
+type Server struct {
+	*httptest.Server
+	Backend *memoss.Backend
+
+	requestNo int64
+}
+
+// Error response body
+type errorResult struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestId string   `xml:"RequestId"`
+}
+
+// Start new server with empty storage, caller should Close it
+func NewServer() *Server {
+	s := &Server{Backend: memoss.New()}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Start new server with created bucket
+func NewServerWithBucket(bucket string) (*Server, error) {
+	s := NewServer()
+	err := s.Backend.CreateBucket(context.Background(), bucket)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestId := fmt.Sprintf("%024X", atomic.AddInt64(&s.requestNo, 1))
+	w.Header().Set("X-Oss-Request-Id", requestId)
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	ctx := r.Context()
+
+	var err error
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		err = s.listBuckets(ctx, w)
+	case bucket == "":
+		err = s.notImplemented()
+	case key == "":
+		err = s.serveBucket(ctx, w, r, bucket, query)
+	default:
+		err = s.serveObject(ctx, w, r, bucket, key, query)
+	}
+
+	if err != nil {
+		s.writeError(w, r, requestId, err)
+	}
+}
+
+func (s *Server) serveBucket(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket string, query url.Values) error {
+	_, uploads := query["uploads"]
+	_, del := query["delete"]
+	switch {
+	case r.Method == http.MethodPut:
+		return s.Backend.CreateBucket(ctx, bucket)
+	case r.Method == http.MethodPost && del:
+		return s.deleteObjects(ctx, w, r, bucket, query)
+	case r.Method == http.MethodGet && uploads:
+		return s.listMultipartUploads(ctx, w, bucket, query)
+	case r.Method == http.MethodGet:
+		return s.listObjects(ctx, w, bucket, query)
+	}
+	return s.notImplemented()
+}
+
+func (s *Server) serveObject(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key string, query url.Values) error {
+	_, uploads := query["uploads"]
+	uploadId := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPut && uploadId != "":
+		return s.uploadPart(ctx, w, r, bucket, key, uploadId, query)
+	case r.Method == http.MethodPut:
+		return s.putObject(ctx, w, r, bucket, key)
+	case r.Method == http.MethodPost && uploads:
+		return s.initiateMultipartUpload(ctx, w, bucket, key)
+	case r.Method == http.MethodPost && uploadId != "":
+		return s.completeMultipartUpload(ctx, w, r, bucket, key, uploadId)
+	case r.Method == http.MethodGet && uploadId != "":
+		return s.listUploadedParts(ctx, w, bucket, key, uploadId, query)
+	case r.Method == http.MethodGet:
+		return s.getObject(ctx, w, r, bucket, key)
+	case r.Method == http.MethodHead:
+		return s.headObject(ctx, w, bucket, key)
+	case r.Method == http.MethodDelete && uploadId != "":
+		return s.abortMultipartUpload(ctx, w, bucket, key, uploadId)
+	case r.Method == http.MethodDelete:
+		return s.deleteObject(ctx, w, bucket, key)
+	}
+	return s.notImplemented()
+}
+
+func (s *Server) listBuckets(ctx context.Context, w http.ResponseWriter) error {
+	names, err := s.Backend.ListBuckets(ctx)
+	if err != nil {
+		return err
+	}
+
+	var result oss.ListBucketsResult
+	for _, name := range names {
+		result.Buckets = append(result.Buckets, oss.BucketProperties{Name: name, StorageClass: "Standard"})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) listObjects(ctx context.Context, w http.ResponseWriter, bucket string, query url.Values) error {
+	result, err := s.Backend.ListObjects(ctx, bucket, query.Get("prefix"), query.Get("delimiter"), query.Get("marker"))
+	if err != nil {
+		return err
+	}
+
+	if query.Get("encoding-type") == "url" {
+		result.Prefix = url.QueryEscape(result.Prefix)
+		result.Marker = url.QueryEscape(result.Marker)
+		result.Delimiter = url.QueryEscape(result.Delimiter)
+		result.NextMarker = url.QueryEscape(result.NextMarker)
+		for i := range result.Objects {
+			result.Objects[i].Key = url.QueryEscape(result.Objects[i].Key)
+		}
+		for i := range result.CommonPrefixes {
+			result.CommonPrefixes[i] = url.QueryEscape(result.CommonPrefixes[i])
+		}
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) listMultipartUploads(ctx context.Context, w http.ResponseWriter, bucket string, query url.Values) error {
+	result, err := s.Backend.ListMultipartUploads(ctx, bucket, query.Get("prefix"), query.Get("key-marker"), query.Get("upload-id-marker"))
+	if err != nil {
+		return err
+	}
+
+	if query.Get("encoding-type") == "url" {
+		result.Prefix = url.QueryEscape(result.Prefix)
+		result.KeyMarker = url.QueryEscape(result.KeyMarker)
+		result.NextKeyMarker = url.QueryEscape(result.NextKeyMarker)
+		for i := range result.Uploads {
+			result.Uploads[i].Key = url.QueryEscape(result.Uploads[i].Key)
+		}
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) putObject(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	err = s.Backend.PutObject(ctx, bucket, key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	headers, err := s.Backend.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", headers.Get("ETag"))
+	w.Header().Set("X-Oss-Hash-Crc64ecma", headers.Get("X-Oss-Hash-Crc64ecma"))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) getObject(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	headers, err := s.Backend.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	start, end, ranged, err := parseRange(r.Header.Get("Range"), size)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.Backend.GetObject(ctx, bucket, key, start, end, r.Header.Get("If-Match"))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	for name, values := range headers {
+		w.Header()[name] = values
+	}
+	status := http.StatusOK
+	if ranged {
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		w.Header().Del("X-Oss-Hash-Crc64ecma")
+	}
+	w.WriteHeader(status)
+	_, err = io.Copy(w, body)
+	return ignoreAfterHeader(err)
+}
+
+func (s *Server) headObject(ctx context.Context, w http.ResponseWriter, bucket, key string) error {
+	headers, err := s.Backend.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	for name, values := range headers {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) deleteObject(ctx context.Context, w http.ResponseWriter, bucket, key string) error {
+	err := s.Backend.DeleteObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) deleteObjects(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket string, query url.Values) error {
+	var request struct {
+		XMLName xml.Name `xml:"Delete"`
+		Quiet   bool     `xml:"Quiet"`
+		Objects []struct {
+			Key string `xml:"Key"`
+		} `xml:"Object"`
+	}
+	err := xml.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return oss.ServiceError{Code: "MalformedXML", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	keys := make([]string, 0, len(request.Objects))
+	for _, object := range request.Objects {
+		keys = append(keys, object.Key)
+	}
+	err = s.Backend.DeleteObjects(ctx, bucket, keys)
+	if err != nil {
+		return err
+	}
+
+	type deleted struct {
+		Key string `xml:"Key"`
+	}
+	result := struct {
+		XMLName      xml.Name  `xml:"DeleteResult"`
+		EncodingType string    `xml:"EncodingType,omitempty"`
+		Deleted      []deleted `xml:"Deleted"`
+	}{EncodingType: query.Get("encoding-type")}
+	for _, key := range keys {
+		if result.EncodingType == "url" {
+			key = url.QueryEscape(key)
+		}
+		result.Deleted = append(result.Deleted, deleted{Key: key})
+	}
+	if request.Quiet { // Quiet mode reports only failed keys
+		result.Deleted = nil
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) initiateMultipartUpload(ctx context.Context, w http.ResponseWriter, bucket, key string) error {
+	uploadId, err := s.Backend.InitiateMultipartUpload(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	return writeXML(w, http.StatusOK, oss.InitiateMultipartUploadResult{
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: uploadId,
+	})
+}
+
+func (s *Server) uploadPart(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key, uploadId string, query url.Values) error {
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		return oss.ServiceError{Code: "InvalidArgument", Message: "Invalid part number.", StatusCode: http.StatusBadRequest}
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	part, err := s.Backend.UploadPart(ctx, bucket, key, uploadId, partNumber, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", part.ETag)
+	w.Header().Set("X-Oss-Hash-Crc64ecma", strconv.FormatUint(crc64.Checksum(data, crcTable), 10))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *Server) listUploadedParts(ctx context.Context, w http.ResponseWriter, bucket, key, uploadId string, query url.Values) error {
+	partNumberMarker := 0
+	if marker := query.Get("part-number-marker"); marker != "" {
+		var err error
+		partNumberMarker, err = strconv.Atoi(marker)
+		if err != nil {
+			return oss.ServiceError{Code: "InvalidArgument", Message: "Invalid part number marker.", StatusCode: http.StatusBadRequest}
+		}
+	}
+
+	result, err := s.Backend.ListUploadedParts(ctx, bucket, key, uploadId, partNumberMarker)
+	if err != nil {
+		return err
+	}
+
+	if query.Get("encoding-type") == "url" {
+		result.Key = url.QueryEscape(result.Key)
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (s *Server) completeMultipartUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, bucket, key, uploadId string) error {
+	var request struct {
+		XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+		Parts   []oss.UploadPart `xml:"Part"`
+	}
+	err := xml.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		return oss.ServiceError{Code: "MalformedXML", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	err = s.Backend.CompleteMultipartUpload(ctx, bucket, key, uploadId, request.Parts)
+	if err != nil {
+		return err
+	}
+
+	headers, err := s.Backend.HeadObject(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+	return writeXML(w, http.StatusOK, oss.CompleteMultipartUploadResult{
+		Location: s.URL + "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     headers.Get("ETag"),
+	})
+}
+
+func (s *Server) abortMultipartUpload(ctx context.Context, w http.ResponseWriter, bucket, key, uploadId string) error {
+	err := s.Backend.AbortMultipartUpload(ctx, bucket, key, uploadId)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) notImplemented() error {
+	return oss.ServiceError{Code: "NotImplemented", Message: "Operation is not supported by emulator.", StatusCode: http.StatusNotImplemented}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, requestId string, err error) {
+	if errors.Is(err, errHeaderWritten) {
+		return
+	}
+
+	serviceErr := oss.ServiceError{Code: "InternalError", Message: err.Error(), StatusCode: http.StatusInternalServerError}
+	errors.As(err, &serviceErr)
+
+	body, _ := xml.Marshal(errorResult{
+		Code:      serviceErr.Code,
+		Message:   serviceErr.Message,
+		RequestId: requestId,
+	})
+	if r.Method == http.MethodHead {
+		w.Header().Set("X-Oss-Err", base64.StdEncoding.EncodeToString(body))
+		w.WriteHeader(serviceErr.StatusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(serviceErr.StatusCode)
+	_, _ = w.Write(body)
+}
+
+var errHeaderWritten = errors.New("response header is already written")
+
+// Errors of writing body can't be reported to client anymore
+func ignoreAfterHeader(err error) error {
+	if err != nil {
+		return errHeaderWritten
+	}
+	return nil
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(status)
+	_, err = io.WriteString(w, xml.Header+string(body))
+	return ignoreAfterHeader(err)
+}
+
+// Parse single range "bytes=start-end", "bytes=start-" or "bytes=-suffix" of object of "size"
+func parseRange(header string, size int64) (start, end int64, ranged bool, err error) {
+	if header == "" {
+		return 0, size - 1, false, nil
+	}
+
+	invalid := oss.ServiceError{Code: "InvalidRange", Message: "The requested range is not satisfiable.", StatusCode: http.StatusRequestedRangeNotSatisfiable}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size - 1, false, nil
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, size - 1, false, nil
+	}
+
+	switch {
+	case first == "":
+		suffix, errParse := strconv.ParseInt(last, 10, 64)
+		if errParse != nil {
+			return 0, size - 1, false, nil
+		}
+		if suffix > size {
+			suffix = size
+		}
+		start, end = size-suffix, size-1
+	default:
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return 0, size - 1, false, nil
+		}
+		end = size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return 0, size - 1, false, nil
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+	}
+
+	if start > end || start >= size {
+		return 0, 0, false, invalid
+	}
+	return start, end, true, nil
+}
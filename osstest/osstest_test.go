@@ -0,0 +1,67 @@
+package osstest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+func TestServer(t *testing.T) {
+	server, err := NewServerWithBucket("bucket")
+	if err != nil {
+		t.Fatalf("Failed to start server: %s", err)
+	}
+	defer server.Close()
+
+	client, err := oss.New(server.URL, "test", "test")
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	bucket, err := client.Bucket("bucket")
+	if err != nil {
+		t.Fatalf("Failed to get bucket: %s", err)
+	}
+
+	err = bucket.PutObject("dir/file name+.txt", strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("Failed to put object: %s", err)
+	}
+
+	body, err := bucket.GetObject("dir/file name+.txt", oss.Range(2, 4))
+	if err != nil {
+		t.Fatalf("Failed to get range: %s", err)
+	}
+	data, _ := io.ReadAll(body)
+	_ = body.Close()
+	if string(data) != "234" {
+		t.Fatalf("Failed to get range: %q", data)
+	}
+
+	_, err = bucket.GetObject("dir/file name+.txt", oss.IfMatch("\"other\""))
+	var serviceErr oss.ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("Failed to check If-Match: %v", err)
+	}
+
+	result, err := bucket.ListObjects(oss.Prefix("dir/"), oss.Delimiter("/"))
+	if err != nil || len(result.Objects) != 1 || result.Objects[0].Key != "dir/file name+.txt" {
+		t.Fatalf("Failed to list objects: %v %s", result.Objects, err)
+	}
+
+	deleted, err := bucket.DeleteObjects([]string{"dir/file name+.txt", "missing"})
+	if err != nil || len(deleted.DeletedObjects) != 2 || deleted.DeletedObjects[0] != "dir/file name+.txt" {
+		t.Fatalf("Failed to delete objects: %v %s", deleted.DeletedObjects, err)
+	}
+	if result, _ = bucket.ListObjects(oss.Prefix("dir/")); len(result.Objects) != 0 {
+		t.Fatalf("Failed to delete objects: %v", result.Objects)
+	}
+
+	_, err = bucket.GetObjectDetailedMeta("missing")
+	if !errors.As(err, &serviceErr) || serviceErr.StatusCode != http.StatusNotFound || serviceErr.Code != "NoSuchKey" {
+		t.Fatalf("Failed to report missing object: %v", err)
+	}
+}
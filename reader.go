@@ -0,0 +1,199 @@
+package alioss
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// Handle of remote object for sequential and random access.
+// Content is fetched by ranges of part size, which are read ahead and kept for following reads.
+type Reader struct {
+	alioss    AliOss
+	ctx       context.Context
+	key       string
+	etag      string // Reads fail with ErrObjectChanged if object gets other ETag
+	size      int64
+	readAhead int64
+
+	mu        sync.Mutex
+	offset    int64  // Offset of Read and Seek
+	buf       []byte // Read ahead content
+	bufOffset int64
+	closed    bool
+}
+
+// Open remote "key" for reading, part size of options sets size of read ahead
+func (alioss AliOss) Open(ctx context.Context, key string, opts ...TransferOption) (*Reader, error) {
+	cfg := alioss.downloadConfig(opts)
+	err := cfg.validateDownload()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open key %s: %w", key, err)
+	}
+
+	info, err := alioss.StatContext(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open key %s: %w", key, err)
+	}
+
+	return &Reader{
+		alioss:    alioss,
+		ctx:       ctx,
+		key:       info.Key,
+		etag:      info.ETag,
+		size:      info.Size,
+		readAhead: cfg.PartSize,
+	}, nil
+}
+
+// Get size of object
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Read from offset of last Read or Seek
+func (r *Reader) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	offset := r.offset
+	r.mu.Unlock()
+
+	n, err = r.ReadAt(p, offset)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	r.mu.Lock()
+	r.offset = offset + int64(n)
+	r.mu.Unlock()
+	return
+}
+
+// Set offset of next Read
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("Failed to seek key %s: invalid whence %d", r.key, whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("Failed to seek key %s: negative offset %d", r.key, offset)
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+// Read at "off" independently of Read and Seek, safe for parallel calls
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Failed to read key %s: negative offset %d", r.key, off)
+	}
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return 0, fs.ErrClosed
+	}
+
+	var copied int
+	for n < len(p) && off+int64(n) < r.size {
+		copied, err = r.cached(p[n:], off+int64(n))
+		if err != nil {
+			return n, err
+		}
+		if copied > 0 {
+			n += copied
+			continue
+		}
+
+		start := off + int64(n)
+		if int64(len(p)-n) >= r.readAhead { // Large read doesn't need read ahead
+			end := min(start+int64(len(p)-n), r.size)
+			copied, err = r.fetch(p[n:end-off], start)
+			n += copied
+			if err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		buf := make([]byte, min(start+r.readAhead, r.size)-start)
+		_, err = r.fetch(buf, start)
+		if err != nil {
+			return n, err
+		}
+		r.mu.Lock()
+		r.buf, r.bufOffset = buf, start
+		r.mu.Unlock()
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Forget read ahead content, following reads fail
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return fs.ErrClosed
+	}
+	r.closed = true
+	r.buf = nil
+	return nil
+}
+
+// Copy read ahead content at "off" to "p"
+func (r *Reader) cached(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, fs.ErrClosed
+	}
+	if off < r.bufOffset || off >= r.bufOffset+int64(len(r.buf)) {
+		return 0, nil
+	}
+	return copy(p, r.buf[off-r.bufOffset:]), nil
+}
+
+// Fill "p" with content at "off"
+func (r *Reader) fetch(p []byte, off int64) (n int, err error) {
+	r.alioss.Log.Printf("Get range %d-%d of key %s\n", off, off+int64(len(p))-1, r.key)
+
+	written, err := r.alioss.getRange(r.ctx, r.alioss.Retry, r.key, r.etag, off, int64(len(p)), &sliceWriter{p: p})
+	if isPreconditionFailed(err) {
+		err = ErrObjectChanged
+	}
+	if err != nil {
+		return int(written), fmt.Errorf("Failed to read key %s at offset %d: %w", r.key, off, err)
+	}
+	return int(written), nil
+}
+
+// Writer filling slice from its start
+type sliceWriter struct {
+	p []byte
+	n int
+}
+
+func (w *sliceWriter) Write(p []byte) (int, error) {
+	n := copy(w.p[w.n:], p)
+	w.n += n
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
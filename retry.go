@@ -0,0 +1,303 @@
+package alioss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+const (
+	DefaultRetryMaxAttempts int           = 3
+	DefaultRetryBackoff     time.Duration = 200 * time.Millisecond
+	DefaultRetryMaxBackoff  time.Duration = 10 * time.Second
+	DefaultRetryJitter      float64       = 0.5
+)
+
+// Policy of retries of failed requests to OSS.
+// Zero values are replaced by DefaultRetry* constants.
+type RetryPolicy struct {
+	MaxAttempts    int           // Number of attempts of request including the first one, 1 disables retries
+	Backoff        time.Duration // Delay before the first retry, it doubles before every next retry
+	MaxBackoff     time.Duration // Limit of delay between retries
+	Jitter         float64       // Fraction of delay randomly cut off to spread retries of parallel requests, negative disables jitter
+	AttemptTimeout time.Duration // Timeout of single attempt until response, zero means no timeout
+
+	Retryable func(error) bool // Check if failed request can be repeated, default is IsRetryable
+}
+
+// Check if request has failed because of temporary condition:
+// server error, throttling, timeout or broken connection.
+// Other client errors like missing object or access denial aren't retryable.
+func IsRetryable(err error) bool {
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) {
+		switch serviceErr.Code {
+		case "Throttling", "SlowDown", "RequestTimeout", "ServiceUnavailable":
+			return true
+		}
+		return isRetryableStatus(serviceErr.StatusCode)
+	}
+	var statusErr oss.UnexpectedStatusCodeError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.Got())
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) { // Timeout of attempt
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) { // Connection closed by server
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isRetryableStatus(status int) bool {
+	return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests || status == http.StatusRequestTimeout
+}
+
+// Get policy with defaults instead of zero values
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if policy.Backoff == 0 {
+		policy.Backoff = DefaultRetryBackoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if policy.Jitter == 0 {
+		policy.Jitter = DefaultRetryJitter
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsRetryable
+	}
+	return policy
+}
+
+// Get delay before retry following failed "attempt"
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < attempt && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, policy.MaxBackoff)
+
+	if policy.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * min(policy.Jitter, 1) * float64(delay))
+	}
+	return delay
+}
+
+// Run "op" until it succeeds, fails with not retryable error or runs out of attempts
+func (policy RetryPolicy) do(ctx context.Context, logger *log.Logger, name string, op func(ctx context.Context) error) error {
+	policy = policy.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := policy.attempt(ctx, op)
+		if err == nil || ctx.Err() != nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		logger.Printf("Try %d of %s has failed: %s. Repeat in %s...\n", attempt, name, err, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Run single attempt of "op" within attempt timeout
+func (policy RetryPolicy) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if policy.AttemptTimeout <= 0 {
+		return op(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, policy.AttemptTimeout)
+	defer cancel()
+	return op(attemptCtx)
+}
+
+// Backend which repeats failed requests by retry policy
+type retryingBackend struct {
+	backend Backend
+	policy  RetryPolicy
+	log     *log.Logger
+}
+
+func (b retryingBackend) ListBuckets(ctx context.Context) (list []string, err error) {
+	err = b.do(ctx, b.policy, "list buckets", func(ctx context.Context) (err error) {
+		list, err = b.backend.ListBuckets(ctx)
+		return
+	})
+	return
+}
+
+func (b retryingBackend) CreateBucket(ctx context.Context, bucket string) error {
+	return b.do(ctx, b.policy, "create bucket "+bucket, func(ctx context.Context) error {
+		return b.backend.CreateBucket(ctx, bucket)
+	})
+}
+
+func (b retryingBackend) ListObjects(ctx context.Context, bucket, prefix, delimiter, marker string) (result oss.ListObjectsResult, err error) {
+	err = b.do(ctx, b.policy, "list objects "+prefix, func(ctx context.Context) (err error) {
+		result, err = b.backend.ListObjects(ctx, bucket, prefix, delimiter, marker)
+		return
+	})
+	return
+}
+
+func (b retryingBackend) HeadObject(ctx context.Context, bucket, key string) (headers http.Header, err error) {
+	err = b.do(ctx, b.policy, "head object "+key, func(ctx context.Context) (err error) {
+		headers, err = b.backend.HeadObject(ctx, bucket, key)
+		return
+	})
+	return
+}
+
+// Attempt timeout limits request until response, but not reading of body, which is bound to "ctx" until close
+func (b retryingBackend) GetObject(ctx context.Context, bucket, key string, start, end int64, ifMatch string) (body io.ReadCloser, err error) {
+	err = b.do(ctx, b.policy, "get object "+key, func(attemptCtx context.Context) error {
+		requestCtx, cancel := context.WithCancel(ctx)
+		stop := context.AfterFunc(attemptCtx, cancel)
+
+		body, err = b.backend.GetObject(requestCtx, bucket, key, start, end, ifMatch)
+		if !stop() { // Attempt has timed out
+			if err == nil {
+				_ = body.Close()
+				err = attemptCtx.Err()
+			}
+			body = nil
+		}
+		if err != nil {
+			cancel()
+			return err
+		}
+		body = cancelingReadCloser{ReadCloser: body, cancel: cancel}
+		return nil
+	})
+	return
+}
+
+// Rewindable readers are repeated from their current offset, other readers aren't retried
+func (b retryingBackend) PutObject(ctx context.Context, bucket, key string, reader io.Reader) error {
+	policy, rewind := b.rewindable(reader)
+	return b.do(ctx, policy, "put object "+key, func(ctx context.Context) error {
+		if err := rewind(); err != nil {
+			return err
+		}
+		return b.backend.PutObject(ctx, bucket, key, reader)
+	})
+}
+
+func (b retryingBackend) DeleteObject(ctx context.Context, bucket, key string) error {
+	return b.do(ctx, b.policy, "delete object "+key, func(ctx context.Context) error {
+		return b.backend.DeleteObject(ctx, bucket, key)
+	})
+}
+
+func (b retryingBackend) DeleteObjects(ctx context.Context, bucket string, keys []string) error {
+	return b.do(ctx, b.policy, fmt.Sprintf("delete %d objects", len(keys)), func(ctx context.Context) error {
+		return b.backend.DeleteObjects(ctx, bucket, keys)
+	})
+}
+
+func (b retryingBackend) InitiateMultipartUpload(ctx context.Context, bucket, key string) (uploadId string, err error) {
+	err = b.do(ctx, b.policy, "initiate upload "+key, func(ctx context.Context) (err error) {
+		uploadId, err = b.backend.InitiateMultipartUpload(ctx, bucket, key)
+		return
+	})
+	return
+}
+
+// Rewindable readers are repeated from their current offset, other readers aren't retried
+func (b retryingBackend) UploadPart(ctx context.Context, bucket, key, uploadId string, partNumber int, reader io.Reader, size int64) (part oss.UploadPart, err error) {
+	policy, rewind := b.rewindable(reader)
+	err = b.do(ctx, policy, fmt.Sprintf("upload part number %d for key %s", partNumber, key), func(ctx context.Context) (err error) {
+		if err = rewind(); err != nil {
+			return
+		}
+		part, err = b.backend.UploadPart(ctx, bucket, key, uploadId, partNumber, reader, size)
+		return
+	})
+	return
+}
+
+func (b retryingBackend) ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIdMarker string) (result oss.ListMultipartUploadResult, err error) {
+	err = b.do(ctx, b.policy, "list uploads "+prefix, func(ctx context.Context) (err error) {
+		result, err = b.backend.ListMultipartUploads(ctx, bucket, prefix, keyMarker, uploadIdMarker)
+		return
+	})
+	return
+}
+
+func (b retryingBackend) ListUploadedParts(ctx context.Context, bucket, key, uploadId string, partNumberMarker int) (result oss.ListUploadedPartsResult, err error) {
+	err = b.do(ctx, b.policy, "list parts of upload id "+uploadId, func(ctx context.Context) (err error) {
+		result, err = b.backend.ListUploadedParts(ctx, bucket, key, uploadId, partNumberMarker)
+		return
+	})
+	return
+}
+
+func (b retryingBackend) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadId string, parts []oss.UploadPart) error {
+	return b.do(ctx, b.policy, "complete upload id "+uploadId, func(ctx context.Context) error {
+		return b.backend.CompleteMultipartUpload(ctx, bucket, key, uploadId, parts)
+	})
+}
+
+func (b retryingBackend) AbortMultipartUpload(ctx context.Context, bucket, key, uploadId string) error {
+	return b.do(ctx, b.policy, "abort upload id "+uploadId, func(ctx context.Context) error {
+		return b.backend.AbortMultipartUpload(ctx, bucket, key, uploadId)
+	})
+}
+
+// Run request "op" by "policy", errors are reported as *RequestError if OSS has responded
+func (b retryingBackend) do(ctx context.Context, policy RetryPolicy, name string, op func(ctx context.Context) error) error {
+	return newRequestError(policy.do(ctx, b.log, name, op))
+}
+
+// Get policy for requests with body of "reader" and function, which returns reader to start of body before attempt
+func (b retryingBackend) rewindable(reader io.Reader) (RetryPolicy, func() error) {
+	seeker, ok := reader.(io.Seeker)
+	var start int64
+	var err error
+	if ok {
+		start, err = seeker.Seek(0, io.SeekCurrent)
+	}
+	if !ok || err != nil {
+		policy := b.policy
+		policy.MaxAttempts = 1
+		return policy, func() error { return nil }
+	}
+	return b.policy, func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}
+}
+
+// Body of response, which releases context of request on close
+type cancelingReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r cancelingReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,233 @@
+package alioss
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	MinUploadPartSize  int64 = 100 * 1024 // 100Kb, OSS limit for all parts except the last one
+	MaxUploadPartSize  int64 = 5 * 1024 * 1024 * 1024
+	MaxUploadPartCount int   = 10000
+
+	// Parts of upload of unknown size grow twice after every such number of parts
+	partSizeGrowthStep int = 1000
+)
+
+// Parameters of uploads and downloads.
+// Zero values are replaced by Default* constants of upload or download.
+// Zero part size of upload is chosen automatically to fit file into OSS limit of parts.
+type TransferConfig struct {
+	PartSize      int64  // Size of transferred part
+	Concurrency   int    // Number of parts transferred in parallel
+	Retries       int    // Number of retries of failed part, negative disables retries, zero is DefaultUploadRetries of upload and AliOss.Retry of download
+	CheckpointDir string // Directory for checkpoints of uploads, default is directory of uploaded file
+	PoolBuffers   bool   // Reuse part buffers between parts and transfers
+	VerifyParts   bool   // Compare also parts recorded in checkpoint with local file on resume of upload
+
+	Checkpoints CheckpointStore // Store of checkpoints of uploads, overrides CheckpointDir
+}
+
+// Option of single transfer, overrides AliOss.Transfer
+type TransferOption func(*TransferConfig)
+
+// Set size of transferred part
+func WithPartSize(size int64) TransferOption {
+	return func(cfg *TransferConfig) {
+		cfg.PartSize = size
+	}
+}
+
+// Set number of parts transferred in parallel
+func WithConcurrency(concurrency int) TransferOption {
+	return func(cfg *TransferConfig) {
+		cfg.Concurrency = concurrency
+	}
+}
+
+// Set number of retries of failed part
+func WithRetries(retries int) TransferOption {
+	return func(cfg *TransferConfig) {
+		cfg.Retries = retries
+	}
+}
+
+// Set directory for checkpoints of uploads
+func WithCheckpointDir(dir string) TransferOption {
+	return func(cfg *TransferConfig) {
+		cfg.CheckpointDir = dir
+	}
+}
+
+// Compare all already uploaded parts with local file on resume of upload, including parts recorded in checkpoint
+func WithVerifyParts() TransferOption {
+	return func(cfg *TransferConfig) {
+		cfg.VerifyParts = true
+	}
+}
+
+// Set store of checkpoints of uploads
+func WithCheckpointStore(store CheckpointStore) TransferOption {
+	return func(cfg *TransferConfig) {
+		cfg.Checkpoints = store
+	}
+}
+
+// Reuse part buffers
+func WithBufferPool() TransferOption {
+	return func(cfg *TransferConfig) {
+		cfg.PoolBuffers = true
+	}
+}
+
+// Pools of part buffers by buffer size
+var bufferPools sync.Map
+
+// Get config of upload from AliOss defaults and options
+func (alioss AliOss) uploadConfig(opts []TransferOption) TransferConfig {
+	cfg := alioss.transferConfig(opts)
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = DefaultUploadConcurrency
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = DefaultUploadRetries
+	}
+	return cfg
+}
+
+// Get config of download from AliOss defaults and options
+func (alioss AliOss) downloadConfig(opts []TransferOption) TransferConfig {
+	cfg := alioss.transferConfig(opts)
+	if cfg.PartSize == 0 {
+		cfg.PartSize = DefaultDownloadPartSize
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = DefaultDownloadConcurrency
+	}
+	return cfg
+}
+
+func (alioss AliOss) transferConfig(opts []TransferOption) TransferConfig {
+	cfg := alioss.Transfer
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// Get config of upload of "size" bytes with chosen part size, negative "size" means unknown size
+func (cfg TransferConfig) forSize(size int64) TransferConfig {
+	if cfg.PartSize == 0 {
+		cfg.PartSize = optimalPartSize(size)
+	}
+	return cfg
+}
+
+// Get size of part, which keeps number of parts of "size" bytes within OSS limit
+func optimalPartSize(size int64) int64 {
+	minPartSize := (size + int64(MaxUploadPartCount) - 1) / int64(MaxUploadPartCount)
+	if minPartSize <= DefaultUploadPartSize {
+		return DefaultUploadPartSize
+	}
+
+	const megabyte = 1024 * 1024
+	return (minPartSize + megabyte - 1) / megabyte * megabyte
+}
+
+// Get size of part "partNumber" of upload of "size" bytes.
+// Parts of upload of unknown (negative) size grow to fit large streams into OSS limit of parts.
+func (cfg TransferConfig) partSize(partNumber int, size int64) int64 {
+	if size >= 0 {
+		return cfg.PartSize
+	}
+
+	growth := (partNumber - 1) / partSizeGrowthStep
+	if cfg.PartSize<<uint(growth) > MaxUploadPartSize || growth > 32 {
+		return MaxUploadPartSize
+	}
+	return cfg.PartSize << uint(growth)
+}
+
+// Get policy of retries of parts, "Retries" overrides number of attempts of "policy"
+func (cfg TransferConfig) retryPolicy(policy RetryPolicy) RetryPolicy {
+	if cfg.Retries != 0 {
+		policy.MaxAttempts = max(cfg.Retries, 0) + 1
+	}
+	return policy
+}
+
+// Check config of upload of "size" bytes against limits of OSS multipart upload, negative "size" means unknown size
+func (cfg TransferConfig) validateUpload(size int64) error {
+	if cfg.PartSize < MinUploadPartSize || cfg.PartSize > MaxUploadPartSize {
+		return fmt.Errorf("Invalid part size %d: must be between %d and %d", cfg.PartSize, MinUploadPartSize, MaxUploadPartSize)
+	}
+	if parts := (size + cfg.PartSize - 1) / cfg.PartSize; size >= 0 && parts > int64(MaxUploadPartCount) {
+		return fmt.Errorf("Invalid part size %d: %d bytes need %d parts, more than %d", cfg.PartSize, size, parts, MaxUploadPartCount)
+	}
+	if cfg.Concurrency < 1 {
+		return fmt.Errorf("Invalid concurrency %d: must be positive", cfg.Concurrency)
+	}
+	return nil
+}
+
+// Check config of download
+func (cfg TransferConfig) validateDownload() error {
+	if cfg.PartSize < 1 {
+		return fmt.Errorf("Invalid part size %d: must be positive", cfg.PartSize)
+	}
+	if cfg.Concurrency < 1 {
+		return fmt.Errorf("Invalid concurrency %d: must be positive", cfg.Concurrency)
+	}
+	return nil
+}
+
+// Get store and id of checkpoint of upload "filePath" to "bucket" and "key".
+// Id is hash of file path, bucket and key, so uploads of the same file to different keys don't share checkpoint.
+// Default store keeps checkpoint "filePath.<id>.cp" next to uploaded file.
+func (cfg TransferConfig) checkpointStore(filePath, bucket, key string) (CheckpointStore, string) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		absPath = filePath
+	}
+	sum := md5.Sum([]byte(absPath + "\n" + bucket + "\n" + key))
+	id := hex.EncodeToString(sum[:])
+
+	switch {
+	case cfg.Checkpoints != nil:
+		return cfg.Checkpoints, id
+	case cfg.CheckpointDir != "":
+		return NewDirCheckpointStore(cfg.CheckpointDir), id
+	default:
+		return NewDirCheckpointStore(filepath.Dir(filePath)), filepath.Base(filePath) + "." + id
+	}
+}
+
+// Get buffer of "size" bytes
+func (cfg TransferConfig) getBuffer(size int64) []byte {
+	if !cfg.PoolBuffers {
+		return make([]byte, size)
+	}
+
+	pool, _ := bufferPools.LoadOrStore(size, &sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		},
+	})
+	return *pool.(*sync.Pool).Get().(*[]byte)
+}
+
+// Return buffer to pool
+func (cfg TransferConfig) putBuffer(buf []byte) {
+	if !cfg.PoolBuffers {
+		return
+	}
+
+	buf = buf[:cap(buf)]
+	if pool, ok := bufferPools.Load(int64(len(buf))); ok {
+		pool.(*sync.Pool).Put(&buf)
+	}
+}
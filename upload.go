@@ -1,17 +1,20 @@
 package alioss
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
@@ -23,88 +26,277 @@ const (
 )
 
 // Upload filePath to destinationPath, where destinationPath contains only folders like /folder/folder2
-func (alioss AliOss) Upload(filePath, destinationPath string) error {
+// Interrupted upload of large file is continued by next call with the help of checkpoint file "filePath.<id>.cp",
+// which is placed into checkpoint directory or checkpoint store if it's configured
+func (alioss AliOss) Upload(filePath, destinationPath string, opts ...TransferOption) error {
+	return alioss.UploadContext(context.Background(), filePath, destinationPath, opts...)
+}
+
+// Same as Upload with context
+func (alioss AliOss) UploadContext(ctx context.Context, filePath, destinationPath string, opts ...TransferOption) error {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("Failed to open file %s for upload: %s\n", filePath, err)
+		return fmt.Errorf("Failed to open file %s for upload: %w", filePath, err)
+	}
+	defer alioss.IoClose(file)
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Failed to stat file %s for upload: %w", filePath, err)
 	}
-	alioss.IoClose(file)
 
 	key := destinationPath + "/" + filepath.Base(filePath)
 	if destinationPath == "" || destinationPath == "/" {
 		key = filepath.Base(filePath)
 	}
+	key = strings.TrimPrefix(key, "/")
 
 	alioss.Log.Printf("Start upload %s to %s", filePath, key)
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
+	cfg := alioss.uploadConfig(opts).forSize(stat.Size())
+	err = cfg.validateUpload(stat.Size())
+	if err != nil {
+		return fmt.Errorf("Failed upload file %s: %w", filePath, err)
+	}
+
+	if stat.Size() <= cfg.PartSize {
+		err = alioss.backend().PutObject(ctx, alioss.Bucket, key, file)
+		if err != nil {
+			return fmt.Errorf("Failed upload file %s: %w", filePath, err)
+		}
+
+		alioss.Log.Println("Successfully uploaded to", key)
+		return nil
+	}
+
+	store, checkpointId := cfg.checkpointStore(filePath, alioss.Bucket, key)
+	uploadId, err := alioss.getCheckpointUploadId(ctx, store, checkpointId, key, stat)
 	if err != nil {
-		return fmt.Errorf("Failed upload file %s: %s\n", filePath, err)
+		return fmt.Errorf("Failed upload file %s: %w", filePath, err)
+	}
+	if uploadId == "" {
+		uploadId, err = alioss.backend().InitiateMultipartUpload(ctx, alioss.Bucket, key)
+		if err != nil {
+			return fmt.Errorf("Failed to initiate upload for key %s: %w", key, err)
+		}
+		alioss.Log.Printf("Initiate upload id %s for key %s\n", uploadId, key)
 	}
 
-	err = bucket.UploadFile(key, filePath, DefaultUploadPartSize, oss.Routines(DefaultUploadConcurrency), oss.Checkpoint(true, ""))
+	err = alioss.ResumeUploadContext(ctx, filePath, key, uploadId, opts...)
 	if err != nil {
-		return fmt.Errorf("Failed upload file %s: %s\n", filePath, err)
+		return fmt.Errorf("Failed upload file %s: %w", filePath, err)
 	}
 
 	alioss.Log.Println("Successfully uploaded to", key)
 	return nil
 }
 
-// Resume upload of local "filePath" to remote "key" identified by "uploadId"
-func (alioss AliOss) ResumeUpload(filePath, key, uploadId string) (err error) {
-	file, err := os.Open(filePath)
+// Get upload id from checkpoint if it's still valid for file, empty if there is no such checkpoint.
+// Upload of checkpoint outdated by change of file is aborted, checkpoint of other bucket or key is ignored.
+func (alioss AliOss) getCheckpointUploadId(ctx context.Context, store CheckpointStore, checkpointId, key string, stat os.FileInfo) (uploadId string, err error) {
+	checkpoint, err := store.Load(checkpointId)
+	if err != nil {
+		alioss.Log.Printf("Failed to load checkpoint %s: %s\n", checkpointId, err)
+		return "", nil
+	}
+	if checkpoint == nil {
+		return "", nil
+	}
+
+	if checkpoint.Bucket != alioss.Bucket || checkpoint.Key != key {
+		alioss.Log.Printf("Checkpoint %s is saved for other upload to %s/%s. Ignore it.\n", checkpointId, checkpoint.Bucket, checkpoint.Key)
+		return "", nil
+	}
+	if !checkpoint.matches(alioss.Bucket, key, stat) {
+		alioss.Log.Printf("Checkpoint %s is outdated. Abort upload id %s\n", checkpointId, checkpoint.UploadId)
+		_ = alioss.AbortUploadContext(ctx, key, checkpoint.UploadId)
+		return "", store.Delete(checkpointId)
+	}
+
+	_, err = alioss.ListPartsContext(ctx, key, checkpoint.UploadId)
+	if isNotFound(err) {
+		alioss.Log.Printf("Upload id %s of checkpoint %s is finished\n", checkpoint.UploadId, checkpointId)
+		return "", store.Delete(checkpointId)
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to open file %s for upload: %s\n", filePath, err)
+		return "", err
 	}
 
-	// Not required, but you could zip the file before uploading it
-	// using io.Pipe read/writer to stream gzip'd file contents.
-	pipeReader, writer := io.Pipe()
+	alioss.Log.Printf("Continue upload id %s from checkpoint %s\n", checkpoint.UploadId, checkpointId)
+	return checkpoint.UploadId, nil
+}
 
-	go func() {
-		bw := bufio.NewWriter(writer)
-		written, err := io.Copy(bw, file)
+// Upload content of reader to "key".
+// Small content is uploaded by single request, large one by parallel multipart upload,
+// which is aborted on failure.
+func (alioss AliOss) UploadReader(ctx context.Context, key string, reader io.Reader, opts ...TransferOption) error {
+	key = strings.TrimPrefix(key, "/")
+	cfg := alioss.uploadConfig(opts).forSize(-1)
+	err := cfg.validateUpload(-1)
+	if err != nil {
+		return fmt.Errorf("Failed upload to key %s: %w", key, err)
+	}
+
+	alioss.Log.Printf("Start upload of reader to %s\n", key)
+
+	firstPart := cfg.getBuffer(cfg.PartSize)
+	partSize, err := io.ReadFull(reader, firstPart)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = alioss.backend().PutObject(ctx, alioss.Bucket, key, bytes.NewReader(firstPart[:partSize]))
+		cfg.putBuffer(firstPart)
 		if err != nil {
-			alioss.Log.Printf("Upload buffer io.Copy error: %s", err)
+			return fmt.Errorf("Failed upload to key %s: %w", key, err)
 		}
-		alioss.Log.Printf("Upload buffer io.Copy written: %d", written)
 
-		alioss.IoClose(file)
-		err = bw.Flush()
-		if err != nil {
-			alioss.Log.Printf("bufio flush error: %s\n", err)
+		alioss.Log.Println("Successfully uploaded to", key)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read content for key %s: %w", key, err)
+	}
+
+	uploadId, err := alioss.backend().InitiateMultipartUpload(ctx, alioss.Bucket, key)
+	if err != nil {
+		return fmt.Errorf("Failed to initiate upload for key %s: %w", key, err)
+	}
+	alioss.Log.Printf("Initiate upload id %s for key %s\n", uploadId, key)
+
+	reader = io.MultiReader(bytes.NewReader(firstPart[:partSize]), reader)
+	produce := func(ctx context.Context, partChan chan<- filePart) error {
+		return alioss.getFileParts(ctx, cfg, partChan, reader)
+	}
+	err = alioss.uploadParts(ctx, cfg, key, uploadId, produce, nil)
+	if err == nil {
+		err = alioss.CompleteUploadContext(ctx, key, uploadId)
+	}
+	if err != nil {
+		alioss.Log.Printf("Abort upload id %s for key %s\n", uploadId, key)
+		errAbort := alioss.AbortUploadContext(context.Background(), key, uploadId)
+		if errAbort != nil {
+			return errors.Join(fmt.Errorf("Failed upload to key %s: %w", key, err), &abortError{uploadId: uploadId, err: errAbort})
 		}
-		alioss.IoClose(writer)
-	}()
+		if err == ctx.Err() {
+			return err
+		}
+		return fmt.Errorf("Failed upload to key %s: %w", key, err)
+	}
+
+	alioss.Log.Println("Successfully uploaded to", key)
+	return nil
+}
+
+// Failure of abort of multipart upload after failed upload
+type abortError struct {
+	uploadId string
+	err      error
+}
+
+func (e *abortError) Error() string {
+	return fmt.Sprintf("Failed to abort upload id %s: %s", e.uploadId, e.err)
+}
+
+func (e *abortError) Unwrap() error {
+	return e.err
+}
+
+// Resume upload of local "filePath" to remote "key" identified by "uploadId"
+func (alioss AliOss) ResumeUpload(filePath, key, uploadId string, opts ...TransferOption) (err error) {
+	return alioss.ResumeUploadContext(context.Background(), filePath, key, uploadId, opts...)
+}
+
+// Same as ResumeUpload with context
+func (alioss AliOss) ResumeUploadContext(ctx context.Context, filePath, key, uploadId string, opts ...TransferOption) (err error) {
+	return alioss.resumeUpload(ctx, filePath, key, uploadId, nil, opts)
+}
+
+// Resume upload, where "verified" parts are already compared with file and aren't read again
+func (alioss AliOss) resumeUpload(ctx context.Context, filePath, key, uploadId string, verified *verifiedParts, opts []TransferOption) (err error) {
+	cfg := alioss.uploadConfig(opts)
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("Failed to open file %s for upload: %w", filePath, err)
+	}
+	defer alioss.IoClose(file)
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Failed to stat file %s for upload: %w", filePath, err)
+	}
 
 	alioss.Log.Printf("Start resume upload %s to %s\n", filePath, key)
 
-	resp, err := alioss.ListParts(key, uploadId)
+	resp, err := alioss.ListPartsContext(ctx, key, uploadId)
 	if err != nil {
-		return fmt.Errorf("Failed to list uploaded parts for key %s of upload id %s: %s\n", key, uploadId, err)
+		return fmt.Errorf("Failed to list uploaded parts for key %s of upload id %s: %w", key, uploadId, err)
 	}
 
-	partQueue := make(chan filePart, DefaultUploadConcurrency)
-	var wg sync.WaitGroup
-	var resultErrors []error
-	for i := 0; i < DefaultUploadConcurrency; i++ {
-		wg.Add(1)
-		go alioss.asyncUploadPart(key, uploadId, partQueue, &wg, &resultErrors)
+	store, checkpointId := cfg.checkpointStore(filePath, alioss.Bucket, key)
+	checkpoint, err := store.Load(checkpointId)
+	if err != nil {
+		alioss.Log.Printf("Failed to load checkpoint %s: %s\n", checkpointId, err)
+	}
+	if checkpoint == nil || checkpoint.UploadId != uploadId || !checkpoint.matches(alioss.Bucket, key, stat) {
+		checkpoint = &UploadCheckpoint{
+			Bucket:   alioss.Bucket,
+			Key:      key,
+			UploadId: uploadId,
+			Size:     stat.Size(),
+			ModTime:  stat.ModTime(),
+		}
 	}
 
-	go alioss.getFileParts(partQueue, pipeReader, resp.UploadedParts)
+	if cfg.PartSize == 0 { // Keep part size of already uploaded parts
+		cfg.PartSize = checkpoint.PartSize
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = uploadedPartSize(resp.UploadedParts, stat.Size())
+	}
+	cfg = cfg.forSize(stat.Size())
+	err = cfg.validateUpload(stat.Size())
+	if err != nil {
+		return fmt.Errorf("Failed to resume upload of file %s: %w", filePath, err)
+	}
+	alioss.Log.Printf("Upload %s with part size %d\n", filePath, cfg.PartSize)
 
-	alioss.Log.Println("Wait for all parts are uploading...")
-	wg.Wait()
+	if checkpoint.PartSize != cfg.PartSize {
+		checkpoint.PartSize = cfg.PartSize
+		checkpoint.Parts = nil
+	}
+	if checkpoint.Parts == nil {
+		checkpoint.Parts = make(map[int]string)
+	}
+	if verified != nil && verified.partSize == cfg.PartSize {
+		maps.Copy(checkpoint.Parts, verified.etags)
+	}
+	uploadedByFile := maps.Clone(checkpoint.Parts) // Parts known to be uploaded from this file
+	err = store.Save(checkpointId, checkpoint)
+	if err != nil {
+		alioss.Log.Printf("Failed to save checkpoint %s: %s\n", checkpointId, err)
+	}
 
-	if len(resultErrors) > 0 {
-		return fmt.Errorf("Failed to resume upload with key %s: %s\n", key, resultErrors)
+	produce := func(ctx context.Context, partChan chan<- filePart) error {
+		return alioss.readFileParts(ctx, cfg, partChan, file, stat.Size(), resp.UploadedParts, uploadedByFile)
+	}
+	saver := newCheckpointSaver(store, checkpointId, checkpoint, alioss.Log, checkpointSaveInterval)
+	err = alioss.uploadParts(ctx, cfg, key, uploadId, produce, saver.addPart)
+	saver.Stop()
+	if err == ctx.Err() && err != nil {
+		alioss.Log.Printf("Resume upload with key %s interrupted: %s\n", key, err)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to resume upload with key %s: %w", key, err)
 	}
 
-	err = alioss.CompleteUpload(key, uploadId)
+	err = alioss.CompleteUploadContext(ctx, key, uploadId)
 	if err != nil {
-		return fmt.Errorf("Failed to complete upload with key %s: %s\n", key, err)
+		return fmt.Errorf("Failed to complete upload with key %s: %w", key, err)
+	}
+
+	err = store.Delete(checkpointId)
+	if err != nil {
+		alioss.Log.Printf("Failed to remove checkpoint %s: %s\n", checkpointId, err)
 	}
 
 	alioss.Log.Println("Successfully resumed upload to", key)
@@ -112,138 +304,411 @@ func (alioss AliOss) ResumeUpload(filePath, key, uploadId string) (err error) {
 	return nil
 }
 
-func (alioss AliOss) getFileParts(partChan chan<- filePart, reader io.Reader, uploadedParts []oss.UploadedPart) {
-	var offset int64
-	lastPartNumber := 1
-	offset = 0
+// Resume upload of local "filePath" to remote "key" by the newest unfinished upload of the key,
+// which uploaded parts match the file, or by new upload if there is no such one
+func (alioss AliOss) ResumeUploadAuto(filePath, key string, opts ...TransferOption) error {
+	return alioss.ResumeUploadAutoContext(context.Background(), filePath, key, opts...)
+}
+
+// Same as ResumeUploadAuto with context
+func (alioss AliOss) ResumeUploadAutoContext(ctx context.Context, filePath, key string, opts ...TransferOption) error {
+	key = strings.TrimPrefix(key, "/")
+	cfg := alioss.uploadConfig(opts)
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("Failed to stat file %s for upload: %w", filePath, err)
+	}
+	store, checkpointId := cfg.checkpointStore(filePath, alioss.Bucket, key)
+	uploadId, err := alioss.getCheckpointUploadId(ctx, store, checkpointId, key, stat)
+	if err != nil {
+		return fmt.Errorf("Failed to find upload of file %s: %w", filePath, err)
+	}
 
+	var verified *verifiedParts
+	if uploadId == "" {
+		uploadId, verified, err = alioss.findUploadId(ctx, filePath, key, cfg)
+		if err != nil {
+			return fmt.Errorf("Failed to find upload of file %s: %w", filePath, err)
+		}
+	}
+
+	if uploadId == "" {
+		uploadId, err = alioss.backend().InitiateMultipartUpload(ctx, alioss.Bucket, key)
+		if err != nil {
+			return fmt.Errorf("Failed to initiate upload for key %s: %w", key, err)
+		}
+		alioss.Log.Printf("Initiate upload id %s for key %s\n", uploadId, key)
+	}
+
+	return alioss.resumeUpload(ctx, filePath, key, uploadId, verified, opts)
+}
+
+// Parts of unfinished upload verified against local file
+type verifiedParts struct {
+	partSize int64
+	etags    map[int]string // ETags of parts by part numbers
+}
+
+// Get id of the newest unfinished upload of "key" with parts matching local "filePath", empty if there is no such upload
+func (alioss AliOss) findUploadId(ctx context.Context, filePath, key string, cfg TransferConfig) (string, *verifiedParts, error) {
+	var uploads []oss.UncompletedUpload
+	var keyMarker, uploadIdMarker string
 	for {
-		part := make([]byte, DefaultUploadPartSize)
-		partSize, errRead := io.ReadFull(reader, part)
-		if errRead != nil && errRead != io.EOF && errRead != io.ErrUnexpectedEOF {
-			alioss.Log.Fatalf("Failed to read part number %d from reader at offset %d: %s\n", lastPartNumber, offset, errRead)
+		resp, err := alioss.backend().ListMultipartUploads(ctx, alioss.Bucket, key, keyMarker, uploadIdMarker)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, upload := range resp.Uploads {
+			if upload.Key == key {
+				uploads = append(uploads, upload)
+			}
 		}
-		alioss.Log.Printf("Read bytes %d for part number %d with size: %d\n", partSize, lastPartNumber, len(part))
+		if !resp.IsTruncated {
+			break
+		}
+		keyMarker, uploadIdMarker = resp.NextKeyMarker, resp.NextUploadIDMarker
+	}
+	sort.SliceStable(uploads, func(i, j int) bool {
+		return uploads[i].Initiated.After(uploads[j].Initiated)
+	})
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer alioss.IoClose(file)
 
-		if int64(partSize) != DefaultUploadPartSize { // Last part of upload, allocate not default []byte
-			alioss.Log.Printf("Last part has number %d and size %d", lastPartNumber, partSize)
-			lastPart := make([]byte, partSize)
-			copy(lastPart, part)
-			part = lastPart
+	stat, err := file.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, upload := range uploads {
+		resp, err := alioss.ListPartsContext(ctx, key, upload.UploadID)
+		if isNotFound(err) { // Completed or aborted meanwhile
+			continue
+		}
+		if err != nil {
+			return "", nil, err
 		}
 
-		partEtag, err := alioss.getPartEtag(part)
+		verified, err := alioss.partsMatch(ctx, file, stat.Size(), cfg, resp.UploadedParts)
 		if err != nil {
-			alioss.Log.Fatalf("Failed to get Etag for part number %d with size %d: %s\n", lastPartNumber, partSize, err)
+			return "", nil, err
+		}
+		if verified != nil {
+			alioss.Log.Printf("Found upload id %s for key %s with %d uploaded parts\n", upload.UploadID, key, len(resp.UploadedParts))
+			return upload.UploadID, verified, nil
 		}
+		alioss.Log.Printf("Uploaded parts of upload id %s for key %s don't match file %s\n", upload.UploadID, key, filePath)
+	}
 
-		alioss.Log.Printf("Part number %d size bytes %d has ETag: %s\n", lastPartNumber, len(part), partEtag)
+	return "", nil, nil
+}
 
-		if true == alioss.needToUpload(uploadedParts, lastPartNumber, partEtag) {
-			alioss.Log.Printf("Send part number %d of size bytes %d to upload", lastPartNumber, len(part))
-			partChan <- filePart{
-				Body:       part,
-				PartNumber: lastPartNumber,
-			}
+// Verify uploaded parts against local file of "size" bytes in parallel, nil if any part doesn't match
+func (alioss AliOss) partsMatch(ctx context.Context, file io.ReaderAt, size int64, cfg TransferConfig, uploadedParts []oss.UploadedPart) (*verifiedParts, error) {
+	if cfg.PartSize == 0 {
+		cfg.PartSize = uploadedPartSize(uploadedParts, size)
+	}
+	cfg = cfg.forSize(size)
+
+	verified := &verifiedParts{partSize: cfg.PartSize, etags: make(map[int]string, len(uploadedParts))}
+	var mu sync.Mutex
+	var mismatch atomic.Bool
+	verifiers := alioss.startVerifiers(cfg, file, uploadedByNumber(uploadedParts), func(part filePart, etag string) {
+		mu.Lock()
+		defer mu.Unlock()
+		verified.etags[part.PartNumber] = etag
+	}, func(part filePart) {
+		cfg.putBuffer(part.Body)
+		mismatch.Store(true)
+	})
+
+	for _, part := range uploadedParts {
+		offset := int64(part.PartNumber-1) * cfg.PartSize
+		if mismatch.Load() || int64(part.Size) > cfg.PartSize || offset+int64(part.Size) > size {
+			mismatch.Store(true)
+			break
+		}
+		err := verifiers.verify(ctx, filePart{PartNumber: part.PartNumber, Offset: offset, Length: int64(part.Size)})
+		if err != nil {
+			_ = verifiers.wait()
+			return nil, err
+		}
+	}
+	err := verifiers.wait()
+	if err != nil || mismatch.Load() {
+		return nil, err
+	}
+	return verified, nil
+}
+
+// Upload to "uploadId" parts sent by "produce", which closes channel of parts after the last one.
+// "done" is called after upload of each part if it's set.
+func (alioss AliOss) uploadParts(ctx context.Context, cfg TransferConfig, key, uploadId string, produce func(context.Context, chan<- filePart) error, done func(oss.UploadPart)) error {
+	group, partCtx := newPartGroup(ctx)
+
+	partQueue := make(chan filePart, cfg.Concurrency)
+	for i := 0; i < cfg.Concurrency; i++ {
+		group.Go(func() error {
+			alioss.asyncUploadPart(partCtx, cfg, key, uploadId, partQueue, group, done)
+			return nil
+		})
+	}
+
+	group.Go(func() error {
+		return produce(partCtx, partQueue)
+	})
+
+	alioss.Log.Println("Wait for all parts are uploading...")
+	return group.Wait()
+}
+
+// Read parts of unknown size stream from reader and send them to upload
+func (alioss AliOss) getFileParts(ctx context.Context, cfg TransferConfig, partChan chan<- filePart, reader io.Reader) (err error) {
+	defer close(partChan)
+
+	var offset int64
+	for partNumber := 1; ; partNumber++ {
+		fullPartSize := cfg.partSize(partNumber, -1)
+		part := cfg.getBuffer(fullPartSize)
+		partSize, errRead := io.ReadFull(reader, part)
+		if errRead != nil && errRead != io.EOF && errRead != io.ErrUnexpectedEOF {
+			cfg.putBuffer(part)
+			alioss.Log.Printf("Failed to read part number %d from reader at offset %d: %s\n", partNumber, offset, errRead)
+			return fmt.Errorf("Failed to read part number %d at offset %d: %w", partNumber, offset, errRead)
+		}
+		alioss.Log.Printf("Read bytes %d for part number %d with size: %d\n", partSize, partNumber, len(part))
+
+		if partSize == 0 && partNumber > 1 { // Previous part was the last one
+			cfg.putBuffer(part)
+			alioss.Log.Printf("All parts are read and sent to upload. Last part is %d, offset is %d", partNumber-1, offset)
+			return nil
+		}
+
+		if int64(partSize) != fullPartSize { // Last part of upload
+			alioss.Log.Printf("Last part has number %d and size %d", partNumber, partSize)
+			part = part[:partSize]
+		}
+
+		alioss.sendPart(ctx, cfg, partChan, filePart{Body: part, PartNumber: partNumber})
+		if ctx.Err() != nil {
+			alioss.Log.Printf("Stop reading parts at part number %d: %s\n", partNumber, ctx.Err())
+			return ctx.Err()
 		}
 
 		offset = offset + int64(len(part))
 
 		if errRead == io.EOF || errRead == io.ErrUnexpectedEOF {
-			alioss.Log.Printf("%s. All parts are read and sent to upload. Last part is %d, offset is %d", errRead, lastPartNumber, offset)
-			close(partChan)
-			return
+			alioss.Log.Printf("%s. All parts are read and sent to upload. Last part is %d, offset is %d", errRead, partNumber, offset)
+			return nil
 		}
-
-		lastPartNumber = lastPartNumber + 1
 	}
 }
 
-func (alioss AliOss) needToUpload(uploadedParts []oss.UploadedPart, partNumber int, partEtag string) bool {
-	for _, part := range uploadedParts {
-		if part.PartNumber == partNumber {
-			alioss.Log.Printf("Part number %d with ETag %s found\n", part.PartNumber, string(part.ETag))
-
-			if strings.ToLower(part.ETag) == strings.ToLower(partEtag) {
-				alioss.Log.Printf("Match Etag for part number %d with size %d ETag %s == %s.\n", part.PartNumber, part.Size, string(part.ETag), partEtag)
-				return false
-			} else {
-				alioss.Log.Printf("Mismatch Etag for part number %d with size %d ETag %s != %s. Reuploading...\n", part.PartNumber, part.Size, string(part.ETag), partEtag)
-				return true
+// Read parts of file of "size" bytes, which are missing in uploaded parts, and send them to upload.
+// Uploaded parts are verified in parallel, parts uploaded from this file with known ETags are verified only if it's configured.
+func (alioss AliOss) readFileParts(ctx context.Context, cfg TransferConfig, partChan chan<- filePart, file io.ReaderAt, size int64, uploadedParts []oss.UploadedPart, uploadedByFile map[int]string) (err error) {
+	defer close(partChan)
+
+	uploaded := uploadedByNumber(uploadedParts)
+	verifiers := alioss.startVerifiers(cfg, file, uploaded, nil, func(part filePart) {
+		alioss.sendPart(ctx, cfg, partChan, part)
+	})
+	defer func() {
+		if errWait := verifiers.wait(); err == nil {
+			err = errWait
+		}
+	}()
+
+	partCount := max(1, int((size+cfg.PartSize-1)/cfg.PartSize))
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		part := filePart{
+			PartNumber: partNumber,
+			Offset:     int64(partNumber-1) * cfg.PartSize,
+		}
+		part.Length = min(cfg.PartSize, size-part.Offset)
+
+		uploadedPart, ok := uploaded[partNumber]
+		if ok && int64(uploadedPart.Size) == part.Length {
+			if etag, ok := uploadedByFile[partNumber]; ok && !cfg.VerifyParts && strings.EqualFold(etag, uploadedPart.ETag) {
+				continue
 			}
+			err = verifiers.verify(ctx, part)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		part.Body, err = alioss.readFilePart(cfg, file, part)
+		if err != nil {
+			return err
+		}
+		alioss.sendPart(ctx, cfg, partChan, part)
+		if ctx.Err() != nil {
+			alioss.Log.Printf("Stop reading parts at part number %d: %s\n", partNumber, ctx.Err())
+			return ctx.Err()
 		}
 	}
-	alioss.Log.Printf("Part number %d is not uploaded\n", partNumber)
 
-	return true
+	alioss.Log.Printf("All missing parts of %d are read and sent to upload\n", partCount)
+	return nil
 }
 
-func (alioss AliOss) asyncUploadPart(key string, uploadId string, partChan <-chan filePart, wg *sync.WaitGroup, resultErrors *[]error) {
-	defer wg.Done()
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed to upload part for key %s: %s\n", key, err)
-		return
+// Read part of file at its offset
+func (alioss AliOss) readFilePart(cfg TransferConfig, file io.ReaderAt, part filePart) ([]byte, error) {
+	body := cfg.getBuffer(cfg.PartSize)[:part.Length]
+	n, err := file.ReadAt(body, part.Offset)
+	if n == len(body) {
+		return body, nil
 	}
 
-	for {
-		if part, ok := <-partChan; ok {
-			alioss.Log.Printf("Start to upload part number %d for key %s\n", part.PartNumber, key)
-			var err error
-			for try := 0; try <= DefaultUploadRetries; try++ {
-				_, err = bucket.UploadPart(
-					oss.InitiateMultipartUploadResult{
-						Bucket:   alioss.Bucket,
-						Key:      key,
-						UploadID: uploadId,
-					},
-					bytes.NewReader(part.Body),
-					DefaultUploadPartSize,
-					part.PartNumber,
-				)
+	cfg.putBuffer(body)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF // File is shorter than on start of upload
+	}
+	alioss.Log.Printf("Failed to read part number %d from file at offset %d: %s\n", part.PartNumber, part.Offset, err)
+	return nil, fmt.Errorf("Failed to read part number %d at offset %d: %w", part.PartNumber, part.Offset, err)
+}
+
+// Send part to upload unless upload is interrupted
+func (alioss AliOss) sendPart(ctx context.Context, cfg TransferConfig, partChan chan<- filePart, part filePart) {
+	alioss.Log.Printf("Send part number %d of size bytes %d to upload", part.PartNumber, len(part.Body))
+	select {
+	case partChan <- part:
+	case <-ctx.Done():
+		cfg.putBuffer(part.Body)
+	}
+}
+
+// Get part size of upload from already uploaded first part, 0 if it's unknown
+func uploadedPartSize(uploadedParts []oss.UploadedPart, size int64) int64 {
+	for _, part := range uploadedParts {
+		if part.PartNumber == 1 && int64(part.Size) < size && int64(part.Size) >= MinUploadPartSize {
+			return int64(part.Size)
+		}
+	}
+	return 0
+}
+
+// Get uploaded parts by part numbers
+func uploadedByNumber(uploadedParts []oss.UploadedPart) map[int]oss.UploadedPart {
+	uploaded := make(map[int]oss.UploadedPart, len(uploadedParts))
+	for _, part := range uploadedParts {
+		uploaded[part.PartNumber] = part
+	}
+	return uploaded
+}
+
+// Verifiers of uploaded parts, which read and hash parts of local file in parallel
+type partVerifiers struct {
+	queue   chan filePart
+	errors  chan error
+	workers sync.WaitGroup
+}
+
+// Start verifiers of parts of "file" against "uploaded" parts.
+// Matching part is passed to "matched" with its ETag if it's set, other part is passed to "mismatched" with read body.
+func (alioss AliOss) startVerifiers(cfg TransferConfig, file io.ReaderAt, uploaded map[int]oss.UploadedPart, matched func(filePart, string), mismatched func(filePart)) *partVerifiers {
+	verifiers := &partVerifiers{
+		queue:  make(chan filePart, cfg.Concurrency),
+		errors: make(chan error, cfg.Concurrency),
+	}
+	for i := 0; i < cfg.Concurrency; i++ {
+		verifiers.workers.Add(1)
+		go func() {
+			defer verifiers.workers.Done()
+			for part := range verifiers.queue {
+				body, err := alioss.readFilePart(cfg, file, part)
 				if err != nil {
-					alioss.Log.Printf("Try %d of upload part number %d for key %s has failed: %s. Repeat...", try, part.PartNumber, key, err)
-				} else {
-					break
+					verifiers.errors <- err
+					return
+				}
+				partEtag, err := alioss.getPartEtag(body)
+				if err == nil && !alioss.needToUpload(uploaded, part.PartNumber, partEtag) {
+					cfg.putBuffer(body)
+					if matched != nil {
+						matched(part, uploaded[part.PartNumber].ETag)
+					}
+					continue
 				}
+				part.Body = body
+				mismatched(part)
 			}
+		}()
+	}
+	return verifiers
+}
 
-			if err != nil {
-				resultErr := errors.New(fmt.Sprintf("Failed to upload part number %d for key %s: %s\n", part.PartNumber, key, err))
-				*resultErrors = append(*resultErrors, resultErr)
-				alioss.Log.Printf("%s", resultErr)
-				return
-			}
-			alioss.Log.Printf("Finished upload part number %d for key %s\n", part.PartNumber, key)
-		} else {
-			alioss.Log.Println("Upload channel closed. Return.")
+// Queue part to verify, fails with the first error of reading of file
+func (verifiers *partVerifiers) verify(ctx context.Context, part filePart) error {
+	select {
+	case verifiers.queue <- part:
+		return nil
+	case err := <-verifiers.errors:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-			return
-		}
+// Wait for verification of queued parts, returns the first error of reading of file
+func (verifiers *partVerifiers) wait() error {
+	close(verifiers.queue)
+	verifiers.workers.Wait()
+	if len(verifiers.errors) > 0 {
+		return <-verifiers.errors
 	}
+	return nil
+}
 
-	return
+func (alioss AliOss) needToUpload(uploaded map[int]oss.UploadedPart, partNumber int, partEtag string) bool {
+	part, ok := uploaded[partNumber]
+	if !ok {
+		alioss.Log.Printf("Part number %d is not uploaded\n", partNumber)
+		return true
+	}
+
+	alioss.Log.Printf("Part number %d with ETag %s found\n", part.PartNumber, string(part.ETag))
+	if strings.EqualFold(part.ETag, partEtag) {
+		alioss.Log.Printf("Match Etag for part number %d with size %d ETag %s == %s.\n", part.PartNumber, part.Size, string(part.ETag), partEtag)
+		return false
+	}
+	alioss.Log.Printf("Mismatch Etag for part number %d with size %d ETag %s != %s. Reuploading...\n", part.PartNumber, part.Size, string(part.ETag), partEtag)
+	return true
 }
 
-func (alioss AliOss) uploadPart(key string, partNumber int, uploadId string, body []byte) (err error) {
-	alioss.Log.Printf("Start upload part number %d of key %s for upload id %s\n", partNumber, key, uploadId)
+func (alioss AliOss) asyncUploadPart(ctx context.Context, cfg TransferConfig, key string, uploadId string, partChan <-chan filePart, group *partGroup, done func(oss.UploadPart)) {
+	for part := range partChan {
+		if ctx.Err() != nil { // Drain parts queued before failure, so producer isn't blocked
+			cfg.putBuffer(part.Body)
+			continue
+		}
 
-	bucket, err := alioss.Svc.Bucket(alioss.Bucket)
-	if err != nil {
-		alioss.Log.Printf("Failed to upload part for key %s: %s\n", key, err)
-		return
+		alioss.Log.Printf("Start to upload part number %d for key %s\n", part.PartNumber, key)
+		uploaded, err := alioss.transferBackend(cfg).UploadPart(ctx, alioss.Bucket, key, uploadId, part.PartNumber, bytes.NewReader(part.Body), int64(len(part.Body)))
+		cfg.putBuffer(part.Body)
+
+		if err != nil {
+			alioss.Log.Printf("Failed to upload part number %d for key %s: %s\n", part.PartNumber, key, err)
+			group.failPart(part.PartNumber, err)
+			continue
+		}
+		if done != nil {
+			done(uploaded)
+		}
+		alioss.Log.Printf("Finished upload part number %d for key %s\n", part.PartNumber, key)
 	}
+	alioss.Log.Println("Upload channel closed. Return.")
+}
 
-	_, err = bucket.UploadPart(
-		oss.InitiateMultipartUploadResult{
-			Bucket:   alioss.Bucket,
-			Key:      key,
-			UploadID: uploadId,
-		},
-		bytes.NewReader(body),
-		DefaultUploadPartSize,
-		partNumber,
-	)
+func (alioss AliOss) uploadPart(ctx context.Context, key string, partNumber int, uploadId string, body []byte) (err error) {
+	alioss.Log.Printf("Start upload part number %d of key %s for upload id %s\n", partNumber, key, uploadId)
+
+	_, err = alioss.backend().UploadPart(ctx, alioss.Bucket, key, uploadId, partNumber, bytes.NewReader(body), int64(len(body)))
 
 	return
 }
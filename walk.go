@@ -0,0 +1,129 @@
+package alioss
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// Function called by WalkDir for every folder and file.
+// Folder is visited before its listing, failed listing is reported by second call with error.
+// Returned fs.SkipDir skips folder or remaining files of folder of file, fs.SkipAll stops walk.
+type WalkDirFunc func(key string, info ObjectInfo, err error) error
+
+// Walk folders and files under "prefix" in lexical order of their names, like filepath.WalkDir.
+// Folders are common prefixes of keys, including folders created by CreateFolder.
+// WithListConcurrency sets number of following sibling folders listed in advance,
+// filters of options select visited files.
+func (alioss AliOss) WalkDir(ctx context.Context, prefix string, fn WalkDirFunc, opts ...ListOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Stop listing of skipped folders
+
+	prefix = folderPrefix(prefix)
+
+	cfg := newListConfig(prefix, opts)
+	cfg.recursive = false
+	w := &walker{
+		alioss: alioss,
+		ctx:    ctx,
+		fn:     fn,
+		cfg:    cfg,
+		sem:    make(chan struct{}, cfg.concurrency),
+	}
+	err := w.walk(ObjectInfo{Key: prefix, IsPrefix: true}, w.list(prefix))
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+type walker struct {
+	alioss AliOss
+	ctx    context.Context
+	fn     WalkDirFunc
+	cfg    listConfig
+	sem    chan struct{} // Limit of parallel listings
+}
+
+// Listing of folder, which is ready after close of "done"
+type walkListing struct {
+	list []ObjectInfo
+	err  error
+	done chan struct{}
+}
+
+// Start listing of folder "prefix"
+func (w *walker) list(prefix string) *walkListing {
+	listing := &walkListing{done: make(chan struct{})}
+	go func() {
+		defer close(listing.done)
+		select {
+		case w.sem <- struct{}{}:
+		case <-w.ctx.Done():
+			listing.err = w.ctx.Err()
+			return
+		}
+		defer func() { <-w.sem }()
+
+		listing.list, listing.err = w.alioss.listAll(w.ctx, prefix, w.cfg)
+	}()
+	return listing
+}
+
+// Visit folder "dir" and its content from "listing"
+func (w *walker) walk(dir ObjectInfo, listing *walkListing) error {
+	err := w.fn(dir.Key, dir, nil)
+	if err != nil {
+		return err
+	}
+
+	<-listing.done
+	if listing.err != nil {
+		return w.fn(dir.Key, dir, listing.err)
+	}
+
+	children := make([]ObjectInfo, 0, len(listing.list))
+	for _, child := range listing.list {
+		if child.Key != dir.Key { // Marker of folder created by CreateFolder
+			children = append(children, child)
+		}
+	}
+	// Keys are listed in lexical order, where "dir-1.txt" precedes "dir/", but folder "dir" precedes "dir-1.txt"
+	slices.SortStableFunc(children, func(a, b ObjectInfo) int {
+		return strings.Compare(strings.TrimSuffix(a.Key, "/"), strings.TrimSuffix(b.Key, "/"))
+	})
+
+	listings := make(map[int]*walkListing)
+	next := 0 // Next child to start listing
+	for i, child := range children {
+		if w.ctx.Err() != nil {
+			return w.ctx.Err()
+		}
+		for ; next < len(children) && (next <= i || len(listings) < w.cfg.concurrency); next++ {
+			if children[next].IsPrefix {
+				listings[next] = w.list(children[next].Key)
+			}
+		}
+
+		if !child.IsPrefix {
+			err = w.fn(child.Key, child, nil)
+			if errors.Is(err, fs.SkipDir) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		childListing := listings[i]
+		delete(listings, i)
+		err = w.walk(child, childListing)
+		if err != nil && !errors.Is(err, fs.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package alioss
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Writer of object, which uploads written content by parts in parallel.
+// Upload is completed by Close and aborted by CloseWithError.
+type Writer struct {
+	pipe *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// Get writer to "key". Content is uploaded by single request on Close if it doesn't exceed part size
+func (alioss AliOss) NewWriter(ctx context.Context, key string, opts ...TransferOption) *Writer {
+	key = strings.TrimPrefix(key, "/")
+	pipeReader, pipeWriter := io.Pipe()
+	w := &Writer{
+		pipe: pipeWriter,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		stop := context.AfterFunc(ctx, func() {
+			_ = pipeReader.CloseWithError(ctx.Err()) // Unblock reading of parts
+		})
+		defer stop()
+
+		w.err = alioss.UploadReader(ctx, key, pipeReader, opts...)
+		if w.err != nil {
+			_ = pipeReader.CloseWithError(w.err) // Fail next writes
+		} else {
+			_ = pipeReader.CloseWithError(io.ErrClosedPipe)
+		}
+		close(w.done)
+	}()
+
+	return w
+}
+
+// Write part of content, blocks while all parts are busy with upload
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+// Finish upload and wait for it
+func (w *Writer) Close() error {
+	_ = w.pipe.Close()
+	<-w.done
+	return w.err
+}
+
+// Abort upload, "err" is reported to upload instead of end of content.
+// Returns nil if upload is aborted, otherwise error of upload or its abort.
+func (w *Writer) CloseWithError(err error) error {
+	if err == nil || err == io.EOF { // EOF would complete upload
+		err = io.ErrClosedPipe
+	}
+	_ = w.pipe.CloseWithError(err)
+	<-w.done
+
+	if w.err == nil {
+		return errors.New("Failed to abort upload: upload is completed")
+	}
+	var abortErr *abortError
+	if errors.Is(w.err, err) && !errors.As(w.err, &abortErr) {
+		return nil
+	}
+	return w.err
+}